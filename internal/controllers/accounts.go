@@ -0,0 +1,387 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "github.com/app-clone-tod-auth"
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AccountRequest struct {
+	UserID int `json:"userID,omitzero"`
+}
+
+type AccountResponse struct {
+	Message string   `json:"message,omitzero"`
+	Err     error    `json:"err,omitzero"`
+	Result  *Account `json:"result,omitzero"`
+}
+
+type Account struct {
+	UserID      int       `json:"userId,omitzero"`
+	DeleteAfter time.Time `json:"deleteAfter,omitzero"` // when a pending deletion goes through, unless the user logs in before
+}
+
+// What happens to each kind of content of a deleted account, keyed like customUtil.RetentionDefaults
+type RetentionRules map[string]string
+
+// Handles the deletion state of the caller's account. DELETE schedules the deletion and logs the caller out.
+func (c *Controller) Account(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		var (
+			response *AccountResponse
+			err      error
+		)
+
+		params := &AccountRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			response, err = params.GetAccount(pool, r.Context())
+		case http.MethodDelete:
+			response, err = params.DelAccount(pool, r.Context())
+		default:
+			wr.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Logging in again is what cancels the deletion
+		if r.Method == http.MethodDelete {
+			auth.RemoveCookie(wr, r)
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// Anonymizes every account past its grace period each customUtil.ACCOUNT_PURGE_INTERVAL until ctx is done
+func RunAccountPurges(ctx context.Context, pool *pgxpool.Pool, rules RetentionRules) {
+	ticker := time.NewTicker(customUtil.ACCOUNT_PURGE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if err := PurgeDueAccounts(ctx, pool, rules, time.Now()); err != nil {
+			fmt.Printf("error (purge): %s\n", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Anonymizes the accounts whose grace period ended before now, one transaction each.
+// A failing account is reported and retried on the next run without holding back the others.
+func PurgeDueAccounts(ctx context.Context, pool *pgxpool.Pool, rules RetentionRules, now time.Time) error {
+	rows, _ := pool.Query(ctx, `SELECT "id" FROM "User" WHERE "deleteAfter" <= $1 AND "deletedAt" IS NULL`, now)
+
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		if err := PurgeAccount(pool, ctx, userID, rules, now); err != nil {
+			fmt.Printf("error (purge): user %d: %s\n", userID, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Reads RETENTION_<KIND> env variables over customUtil.RetentionDefaults
+func RetentionRulesFromEnv() (RetentionRules, error) {
+	rules := RetentionRules{}
+
+	for kind, action := range customUtil.RetentionDefaults {
+		if value, ok := os.LookupEnv("RETENTION_" + strings.ToUpper(kind)); ok {
+			action = value
+		}
+
+		if action != customUtil.RETENTION_DELETE && action != customUtil.RETENTION_TOMBSTONE {
+			return nil, fmt.Errorf("unknown retention rule %q for %s", action, kind)
+		}
+		rules[kind] = action
+	}
+
+	return rules, nil
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (a *AccountRequest) GetAccount(p *pgxpool.Pool, ctx context.Context) (*AccountResponse, error) {
+	response := &AccountResponse{}
+
+	if a.UserID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchAccount(p, ctx, a.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (a *AccountRequest) DelAccount(p *pgxpool.Pool, ctx context.Context) (*AccountResponse, error) {
+	response := &AccountResponse{}
+
+	if a.UserID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	grace, err := accountDeletionGrace()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := response.ScheduleDeletion(p, ctx, a.UserID, time.Now().Add(grace)); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+func (a *AccountResponse) FetchAccount(p *pgxpool.Pool, ctx context.Context, userID int) error {
+	var deleteAfter *time.Time
+
+	if err := p.QueryRow(ctx, `SELECT "deleteAfter" FROM "User" WHERE "id" = $1 AND "deletedAt" IS NULL`, userID).Scan(&deleteAfter); err != nil {
+		return err
+	}
+
+	x := &Account{UserID: userID}
+	if deleteAfter != nil {
+		x.DeleteAfter = *deleteAfter
+	}
+
+	a.Err = nil
+	a.Message = "Done!"
+	a.Result = x
+
+	return nil
+}
+
+// Marks the account for deletion at deleteAfter. Asking again keeps the first schedule.
+func (a *AccountResponse) ScheduleDeletion(p *pgxpool.Pool, ctx context.Context, userID int, deleteAfter time.Time) error {
+	x := &Account{UserID: userID}
+
+	err := p.QueryRow(ctx, `
+		UPDATE "User" SET "deleteAfter" = COALESCE("deleteAfter", $2)
+		WHERE "id" = $1 AND "deletedAt" IS NULL
+		RETURNING "deleteAfter"`, userID, deleteAfter).Scan(&x.DeleteAfter)
+
+	if err != nil {
+		return err
+	}
+
+	a.Err = nil
+	a.Message = "Done!"
+	a.Result = x
+
+	return nil
+}
+
+// Anonymizes userID in one transaction, unless they logged in again since the deletion was due.
+//
+// The profile is blanked (the row stays so tombstoned content still has an author to show), and the
+// login credentials, OAuth identities, sessions, settings, notifications, blocks, mutes, follow requests
+// and data exports are removed. Posts, comments, reactions, messages and follows are deleted or tombstoned
+// following rules.
+func PurgeAccount(p *pgxpool.Pool, ctx context.Context, userID int, rules RetentionRules, now time.Time) error {
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// Locking the user makes a concurrent login either restore the account first or wait for the purge
+	var due bool
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE("deleteAfter" <= $2, false) FROM "User"
+		WHERE "id" = $1 AND "deletedAt" IS NULL FOR UPDATE`, userID, now).Scan(&due)
+
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	case !due:
+		return nil
+	}
+
+	statements := []string{
+		// Old versions of comments would outlive a tombstone
+		`DELETE FROM ONLY "CommentRevision" WHERE "commentId" IN (SELECT "id" FROM "Comment" WHERE "authorId" = $1)`,
+		`DELETE FROM ONLY "Mention" WHERE "userId" = $1 OR "authorId" = $1`,
+	}
+
+	switch rules[customUtil.RETENTION_COMMENTS] {
+	case customUtil.RETENTION_DELETE:
+		// Comments with replies left are tombstoned anyway so the replies keep their place in the thread
+		statements = append(statements, `
+			DELETE FROM ONLY "Comment" c WHERE c."authorId" = $1 AND NOT EXISTS (
+				SELECT 1 FROM "Comment" d
+				WHERE d."postId" = c."postId" AND d."depth" > c."depth" AND d."path" LIKE c."path" || '%'
+			)`)
+		fallthrough
+	default:
+		statements = append(statements, `UPDATE "Comment" SET "message" = '', "isDeleted" = true WHERE "authorId" = $1`)
+	}
+
+	switch rules[customUtil.RETENTION_POSTS] {
+	case customUtil.RETENTION_DELETE:
+		statements = append(statements, `DELETE FROM ONLY "Post" WHERE "authorId" = $1`)
+	default:
+		statements = append(statements,
+			`DELETE FROM ONLY "PostTag" WHERE "postId" IN (SELECT "id" FROM "Post" WHERE "authorId" = $1)`,
+			`UPDATE "Post" SET "title" = '', "message" = '', "isDeleted" = true WHERE "authorId" = $1`,
+		)
+	}
+
+	// Tombstoned reactions and follows stay attached to the anonymized account, so counts do not change
+	if rules[customUtil.RETENTION_REACTIONS] == customUtil.RETENTION_DELETE {
+		statements = append(statements,
+			`DELETE FROM ONLY "Reactions" WHERE "reactorId" = $1`,
+			`DELETE FROM ONLY "CommentReaction" WHERE "reactorId" = $1`,
+		)
+	}
+
+	switch rules[customUtil.RETENTION_MESSAGES] {
+	case customUtil.RETENTION_DELETE:
+		statements = append(statements, `DELETE FROM ONLY "Messages" WHERE "authorId" = $1`)
+	default:
+		statements = append(statements, `UPDATE "Messages" SET "content" = '' WHERE "authorId" = $1`)
+	}
+
+	if rules[customUtil.RETENTION_NETWORK] == customUtil.RETENTION_DELETE {
+		statements = append(statements, `DELETE FROM ONLY "UserNetwork" WHERE "followerId" = $1 OR "followingId" = $1`)
+	}
+
+	statements = append(statements,
+		`DELETE FROM ONLY "FollowRequest" WHERE "requesterId" = $1 OR "targetId" = $1`,
+		`DELETE FROM ONLY "UserBlock" WHERE "blockerId" = $1 OR "blockedId" = $1`,
+		`DELETE FROM ONLY "UserMute" WHERE "muterId" = $1 OR "mutedId" = $1`,
+		`DELETE FROM ONLY "Notification" WHERE "userId" = $1 OR "actorId" = $1`,
+		`DELETE FROM ONLY "NotificationPreference" WHERE "userId" = $1`,
+		`DELETE FROM ONLY "UserSettings" WHERE "userId" = $1`,
+		`DELETE FROM ONLY "ProfileHandleHistory" WHERE "userId" = $1`,
+		`DELETE FROM ONLY "Avatar" WHERE "userId" = $1`,
+		`DELETE FROM ONLY "Session" s WHERE `+sessionOwnedBy("s", "$1::integer"),
+	)
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, userID); err != nil {
+			return err
+		}
+	}
+
+	rows, _ := tx.Query(ctx, `DELETE FROM ONLY "DataExport" WHERE "userId" = $1 RETURNING COALESCE("fileName", '')`, userID)
+
+	exports, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE "Profile" SET "firstName" = $2, "lastName" = $3, "bio" = NULL, "title" = NULL,
+			"profileUrl" = NULL, "handle" = NULL, "isPrivate" = false
+		WHERE "userId" = $1`, userID, customUtil.DELETED_USER_FIRST_NAME, customUtil.DELETED_USER_LAST_NAME)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE "User" SET "username" = NULL, "password" = NULL, "email" = NULL, "emailVerifiedAt" = NULL, "googleId" = NULL, "githubId" = NULL,
+			"isAdmin" = false, "deleteAfter" = NULL, "deletedAt" = $2
+		WHERE "id" = $1`, userID, now)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	// Files go last: a failed commit must not leave the profile without its pictures
+	if err := removeExportFiles(exports); err != nil {
+		return err
+	}
+
+	mediaDir, err := MediaDir()
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(filepath.Join(mediaDir, "avatars", strconv.Itoa(userID)))
+}
+
+func (a *AccountRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	a.UserID = userID
+
+	return nil
+}
+
+// --------------------- Utility Layer -------------------------- //
+
+// SQL condition that a "Session" row belongs to userID (an SQL expression of a user id). The table is the
+// express-session store ("sid", "data", "expiresAt") of the former backend, and "data" is the serialized
+// session holding the logged in user under passport.user.
+func sessionOwnedBy(session, userID string) string {
+	return fmt.Sprintf(`(%s."data"::jsonb #>> '{passport,user}') = (%s)::text`, session, userID)
+}
+
+// SQL condition that userID (an SQL expression of a user id) is an account in use: neither anonymized nor
+// waiting for its scheduled deletion. Such accounts are kept out of lookups, suggestions and listings.
+func accountActive(userID string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+		SELECT 1 FROM "User" au WHERE au."id" = %s AND (au."deleteAfter" IS NOT NULL OR au."deletedAt" IS NOT NULL)
+	)`, userID)
+}
+
+// How long a deleted account can still be restored by logging in
+func accountDeletionGrace() (time.Duration, error) {
+	grace, ok := os.LookupEnv("ACCOUNT_DELETION_GRACE")
+	if !ok {
+		return customUtil.ACCOUNT_DELETION_GRACE, nil
+	}
+
+	return time.ParseDuration(grace)
+}
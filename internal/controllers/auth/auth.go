@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/mail"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -32,6 +33,7 @@ type AuthRequest struct {
 	GoogleID string `json:"googleId,omitzero"` // Google provides id as string
 	GithubID int    `json:"githubId,omitzero"` // Github provides id as int
 	Password string `json:"password,omitzero"`
+	Email    string `json:"email,omitzero"` // optional, used for digests
 }
 
 func (ca *AuthHandler) AuthMe(wr http.ResponseWriter, r *http.Request) {
@@ -196,6 +198,12 @@ func (ca *AuthHandler) AuthGoogleCallback(pool *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
+		if err := RestoreAccount(pool, r.Context(), user.ID); err != nil {
+			fmt.Printf("error (auth): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
 		// Set new cookie from new user details
 		if err := SetCookieWithToken(wr, user); err != nil {
 			fmt.Printf("error (auth): %s\n", err.Error())
@@ -356,6 +364,18 @@ func (ca *AuthHandler) AuthGithubCallback(pool *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
+		if err = RestoreAccount(pool, r.Context(), user.ID); err != nil {
+			fmt.Printf("error (auth): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err = githubUser.SyncAvatar(pool, r.Context(), user); err != nil {
+			fmt.Printf("error (auth): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
 		// Set new cookie from new user details
 		if err = SetCookieWithToken(wr, user); err != nil {
 			fmt.Printf("error (auth): %s\n", err.Error())
@@ -427,6 +447,12 @@ func (ca *AuthHandler) AuthLocal(pool *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
+		if err = RestoreAccount(pool, r.Context(), params.ID); err != nil {
+			fmt.Printf("error (auth): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
 		// Remove previous cookie before proceeding (if any)
 		// No error means cookie found
 		if _, err := r.Cookie(customUtil.COOKIE_NAME); err == nil {
@@ -483,6 +509,15 @@ func ParseAuthParams(r *http.Request) (*AuthRequest, error) {
 		return nil, errors.New("bad request body")
 	}
 
+	// Optional
+	if x.Email != "" {
+		addr, err := mail.ParseAddress(x.Email)
+		if err != nil {
+			return nil, err
+		}
+		x.Email = addr.Address
+	}
+
 	return x, nil
 }
 
@@ -512,7 +547,17 @@ func (p *AuthRequest) LocalSignup(pool *pgxpool.Pool) error {
 	}
 
 	// Retrieve auto-generated db ID
-	if err = pool.QueryRow(context.Background(), `INSERT INTO "User" ("username", "password") VALUES ($1, $2) RETURNING "id"`, p.UserName, pw).Scan(&p.ID); err != nil {
+	if err = pool.QueryRow(context.Background(), `INSERT INTO "User" ("username", "password", "email") VALUES ($1, $2, NULLIF($3, '')) RETURNING "id"`, p.UserName, pw, p.Email).Scan(&p.ID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Cancels a pending deletion of the account, since logging in during the grace period restores it.
+// Waits for a purge already running on the account, after which there is nothing left to restore.
+func RestoreAccount(pool *pgxpool.Pool, ctx context.Context, userID int) error {
+	if _, err := pool.Exec(ctx, `UPDATE "User" SET "deleteAfter" = NULL WHERE "id" = $1 AND "deleteAfter" IS NOT NULL AND "deletedAt" IS NULL`, userID); err != nil {
 		return err
 	}
 
@@ -66,7 +66,7 @@ func (g *GithubUser) Signup(pool *pgxpool.Pool, ctx context.Context, user *AuthR
 	}
 
 	// Populate user profile
-	res, err := pool.Exec(ctx, `INSERT INTO "Profile" ("userId", "firstName", "lastName") VALUES ($1, $2, $3)`, user.ID, g.Username, g.Username)
+	res, err := pool.Exec(ctx, `INSERT INTO "Profile" ("userId", "firstName", "lastName", "profileUrl") VALUES ($1, $2, $3, $4)`, user.ID, g.Username, g.Username, g.AVATAR_URL)
 	if err != nil {
 		return err
 	}
@@ -77,3 +77,13 @@ func (g *GithubUser) Signup(pool *pgxpool.Pool, ctx context.Context, user *AuthR
 
 	return nil
 }
+
+// Falls back to the github avatar for profiles without a picture (e.g. after removing an uploaded avatar)
+func (g *GithubUser) SyncAvatar(pool *pgxpool.Pool, ctx context.Context, user *AuthRequest) error {
+	if g.AVATAR_URL == "" {
+		return nil
+	}
+
+	_, err := pool.Exec(ctx, `UPDATE "Profile" SET "profileUrl" = $1 WHERE "userId" = $2 AND "profileUrl" IS NULL`, g.AVATAR_URL, user.ID)
+	return err
+}
@@ -20,6 +20,8 @@ type GoogleUser struct {
 	FirstName string `json:"given_name"`
 	LastName  string `json:"family_name"`
 	Picture   string `json:"picture"`
+	Email     string `json:"email"`
+	Verified  bool   `json:"email_verified"`
 }
 
 func (c *GoogleConfig) SetGoogleClient(scopes []string) error {
@@ -61,8 +63,13 @@ func (g *GoogleUser) GetID(pool *pgxpool.Pool, ctx context.Context, user *AuthRe
 func (g *GoogleUser) Signup(pool *pgxpool.Pool, ctx context.Context, user *AuthRequest) error {
 	var err error
 
-	// If user is new, add to local db and retrieve auto-generated db ID
-	if err = pool.QueryRow(ctx, `INSERT INTO "User" ("googleId") VALUES ($1) RETURNING "id"`, g.ID).Scan(&user.ID); err != nil {
+	// If user is new, add to local db and retrieve auto-generated db ID. Google vouches for verified addresses.
+	err = pool.QueryRow(ctx, `
+		INSERT INTO "User" ("googleId", "email", "emailVerifiedAt")
+		VALUES ($1, NULLIF($2, ''), CASE WHEN $3::boolean AND $2 <> '' THEN CURRENT_TIMESTAMP END)
+		RETURNING "id"`,
+		g.ID, g.Email, g.Verified).Scan(&user.ID)
+	if err != nil {
 		return err
 	}
 
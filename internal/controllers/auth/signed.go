@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Appends a "sig" parameter to values so links sent outside the app (e.g. by email)
+// can be trusted without a session. Adding an "expires" (unix seconds) value makes the link expire.
+func SignValues(values url.Values) (string, error) {
+	sig, err := signature(values)
+	if err != nil {
+		return "", err
+	}
+
+	signed := url.Values{}
+	for key, v := range values {
+		signed[key] = v
+	}
+	signed.Set("sig", sig)
+
+	return signed.Encode(), nil
+}
+
+// Verifies query parameters produced by SignValues
+func VerifySignedValues(query url.Values) error {
+	values := url.Values{}
+	for key, v := range query {
+		if key != "sig" {
+			values[key] = v
+		}
+	}
+
+	expected, err := signature(values)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(query.Get("sig"))) {
+		return errors.New("invalid signature")
+	}
+
+	if expires := values.Get("expires"); expires != "" {
+		unix, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if time.Now().After(time.Unix(unix, 0)) {
+			return errors.New("link expired")
+		}
+	}
+
+	return nil
+}
+
+func signature(values url.Values) (string, error) {
+	secret, err := GetCookieSecret()
+	if err != nil {
+		return "", err
+	}
+
+	// Encode sorts by key, so the same values always produce the same signature
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(values.Encode()))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
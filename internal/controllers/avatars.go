@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	// Register decoders for uploaded avatars
+	_ "image/gif"
+	_ "image/png"
+
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AvatarRequest struct {
+	UserID int
+	Image  image.Image
+}
+
+type AvatarResponse struct {
+	Message string    `json:"message,omitzero"`
+	Err     error     `json:"err,omitzero"`
+	Result  []*Avatar `json:"result,omitzero"`
+}
+
+type Avatar struct {
+	Size int    `json:"size,omitzero"`
+	URL  string `json:"url,omitzero"`
+}
+
+func (c *Controller) Avatar(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		var response *AvatarResponse
+		var err error
+
+		// Oversized uploads are cut off while reading instead of being spooled to disk first
+		r.Body = http.MaxBytesReader(wr, r.Body, customUtil.AVATAR_MAX_BYTES+customUtil.AVATAR_MULTIPART_OVERHEAD)
+
+		params := &AvatarRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				wr.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			response, err = params.PostAvatar(pool, r.Context())
+		case http.MethodDelete:
+			response, err = params.DelAvatar(pool, r.Context())
+		default:
+			wr.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// Returns the directory uploaded media is written to and served from
+func MediaDir() (string, error) {
+	dir, ok := os.LookupEnv("MEDIA_DIR")
+	if !ok {
+		return "", errors.New("environment variable not found")
+	}
+
+	return dir, nil
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (a *AvatarRequest) PostAvatar(p *pgxpool.Pool, ctx context.Context) (*AvatarResponse, error) {
+	response := &AvatarResponse{}
+
+	if a.Image == nil {
+		return nil, errors.New("bad request body")
+	}
+
+	mediaDir, err := MediaDir()
+	if err != nil {
+		return nil, err
+	}
+
+	userDir := filepath.Join(mediaDir, "avatars", strconv.Itoa(a.UserID))
+	if err := os.MkdirAll(userDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// Every upload gets a fresh file name so clients never see a cached old picture
+	stamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	square := customUtil.CropSquare(a.Image)
+	avatars := []*Avatar{}
+
+	for _, size := range customUtil.AvatarSizes {
+		name := fmt.Sprintf("%s-%d.jpg", stamp, size)
+
+		if err := writeJPEG(filepath.Join(userDir, name), customUtil.ResizeSquare(square, size)); err != nil {
+			return nil, err
+		}
+
+		avatars = append(avatars, &Avatar{
+			Size: size,
+			URL:  fmt.Sprintf("%savatars/%d/%s", customUtil.MEDIA_ROUTE, a.UserID, name),
+		})
+	}
+
+	if err := response.CreateAvatar(p, ctx, a.UserID, avatars); err != nil {
+		return nil, err
+	}
+
+	// Files of the previous upload are unreachable once the rows are replaced
+	removeStaleAvatars(userDir, stamp)
+
+	return response, nil
+}
+
+func (a *AvatarRequest) DelAvatar(p *pgxpool.Pool, ctx context.Context) (*AvatarResponse, error) {
+	response := &AvatarResponse{}
+
+	mediaDir, err := MediaDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := response.RemoveAvatar(p, ctx, a.UserID); err != nil {
+		return nil, err
+	}
+
+	if err := os.RemoveAll(filepath.Join(mediaDir, "avatars", strconv.Itoa(a.UserID))); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+func (a *AvatarResponse) CreateAvatar(p *pgxpool.Pool, ctx context.Context, userID int, avatars []*Avatar) error {
+	var defaultURL string
+
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM ONLY "Avatar" WHERE "userId" = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, avatar := range avatars {
+		if _, err := tx.Exec(ctx, `INSERT INTO "Avatar" ("userId", "size", "url") VALUES ($1, $2, $3)`, userID, avatar.Size, avatar.URL); err != nil {
+			return err
+		}
+
+		if avatar.Size == customUtil.AVATAR_DEFAULT_SIZE {
+			defaultURL = avatar.URL
+		}
+	}
+
+	// profileUrl is what Author embeds everywhere
+	res, err := tx.Exec(ctx, `UPDATE "Profile" SET "profileUrl" = $1 WHERE "userId" = $2`, defaultURL, userID)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() != 1 {
+		return errors.New("profile not found")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	a.Err = nil
+	a.Message = "Done!"
+	a.Result = avatars
+
+	return nil
+}
+
+func (a *AvatarResponse) RemoveAvatar(p *pgxpool.Pool, ctx context.Context, userID int) error {
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM ONLY "Avatar" WHERE "userId" = $1`, userID); err != nil {
+		return err
+	}
+
+	// A NULL profileUrl lets OAuth logins fill in their picture again
+	if _, err := tx.Exec(ctx, `UPDATE "Profile" SET "profileUrl" = NULL WHERE "userId" = $1`, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	a.Err = nil
+	a.Message = "Done!"
+	a.Result = nil
+
+	return nil
+}
+
+// Used by FetchProfile in profiles.go
+func GetAvatars(p *pgxpool.Pool, ctx context.Context, userID int) ([]*Avatar, error) {
+	rows, _ := p.Query(ctx, `SELECT "size", "url" FROM "Avatar" WHERE "userId" = $1 ORDER BY "size"`, userID)
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Avatar, error) {
+		x := &Avatar{}
+		if err := row.Scan(&x.Size, &x.URL); err != nil {
+			return nil, err
+		}
+		return x, nil
+	})
+}
+
+func (a *AvatarRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	a.UserID = userID
+
+	// Only uploads carry a body
+	if r.Method != http.MethodPost {
+		return nil
+	}
+
+	if err := r.ParseMultipartForm(customUtil.AVATAR_MAX_BYTES); err != nil {
+		return err
+	}
+
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if header.Size > customUtil.AVATAR_MAX_BYTES {
+		return &http.MaxBytesError{Limit: customUtil.AVATAR_MAX_BYTES}
+	}
+
+	// The header alone tells the size, so oversized images are refused before allocating their pixels
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return err
+	}
+	if config.Width > customUtil.AVATAR_MAX_DIMENSION || config.Height > customUtil.AVATAR_MAX_DIMENSION {
+		return fmt.Errorf("avatar must be at most %dx%d pixels", customUtil.AVATAR_MAX_DIMENSION, customUtil.AVATAR_MAX_DIMENSION)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+	a.Image = img
+
+	return nil
+}
+
+// --------------------- Utility Layer -------------------------- //
+
+func writeJPEG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
+}
+
+// Best-effort cleanup of files not belonging to the upload identified by stamp
+func removeStaleAvatars(dir, stamp string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), stamp+"-") {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
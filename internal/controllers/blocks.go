@@ -0,0 +1,358 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type BlockRequest struct {
+	UserID   int `json:"userID,omitzero"`
+	TargetID int `json:"targetID,omitzero"` // user being blocked or muted
+}
+
+type BlockResponse struct {
+	Message string         `json:"message,omitzero"`
+	Err     error          `json:"err,omitzero"`
+	Result  []*BlockedUser `json:"result"`
+}
+
+// A user the caller blocked or muted
+type BlockedUser struct {
+	UserID    int       `json:"userId,omitzero"`
+	User      Author    `json:"user,omitzero"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+}
+
+// Handles listing the caller's blocks (GET), blocking (POST) and unblocking (DELETE) the user in the path
+func (c *Controller) Block(pool *pgxpool.Pool) http.HandlerFunc {
+	return blockHandler(pool, map[string]func(*BlockRequest, *pgxpool.Pool, context.Context) (*BlockResponse, error){
+		http.MethodGet:    (*BlockRequest).GetBlocks,
+		http.MethodPost:   (*BlockRequest).PostBlock,
+		http.MethodDelete: (*BlockRequest).DelBlock,
+	})
+}
+
+// Handles listing the caller's mutes (GET), muting (POST) and unmuting (DELETE) the user in the path
+func (c *Controller) Mute(pool *pgxpool.Pool) http.HandlerFunc {
+	return blockHandler(pool, map[string]func(*BlockRequest, *pgxpool.Pool, context.Context) (*BlockResponse, error){
+		http.MethodGet:    (*BlockRequest).GetMutes,
+		http.MethodPost:   (*BlockRequest).PostMute,
+		http.MethodDelete: (*BlockRequest).DelMute,
+	})
+}
+
+func blockHandler(pool *pgxpool.Pool, methods map[string]func(*BlockRequest, *pgxpool.Pool, context.Context) (*BlockResponse, error)) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		fn, ok := methods[r.Method]
+		if !ok {
+			wr.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := &BlockRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := fn(params, pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (b *BlockRequest) GetBlocks(p *pgxpool.Pool, ctx context.Context) (*BlockResponse, error) {
+	response := &BlockResponse{}
+
+	if err := response.FetchBlocks(p, ctx, b.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (b *BlockRequest) PostBlock(p *pgxpool.Pool, ctx context.Context) (*BlockResponse, error) {
+	response := &BlockResponse{}
+
+	if b.TargetID == 0 || b.TargetID == b.UserID {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.CreateBlock(p, ctx, b.UserID, b.TargetID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (b *BlockRequest) DelBlock(p *pgxpool.Pool, ctx context.Context) (*BlockResponse, error) {
+	response := &BlockResponse{}
+
+	if b.TargetID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.RemoveBlock(p, ctx, b.UserID, b.TargetID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (b *BlockRequest) GetMutes(p *pgxpool.Pool, ctx context.Context) (*BlockResponse, error) {
+	response := &BlockResponse{}
+
+	if err := response.FetchMutes(p, ctx, b.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (b *BlockRequest) PostMute(p *pgxpool.Pool, ctx context.Context) (*BlockResponse, error) {
+	response := &BlockResponse{}
+
+	if b.TargetID == 0 || b.TargetID == b.UserID {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.CreateMute(p, ctx, b.UserID, b.TargetID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (b *BlockRequest) DelMute(p *pgxpool.Pool, ctx context.Context) (*BlockResponse, error) {
+	response := &BlockResponse{}
+
+	if b.TargetID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.RemoveMute(p, ctx, b.UserID, b.TargetID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+// Users blockerID blocked, latest first
+func (b *BlockResponse) FetchBlocks(p *pgxpool.Pool, ctx context.Context, blockerID int) error {
+	rows, _ := p.Query(ctx, `SELECT "blockedId", "createdAt" FROM "UserBlock" WHERE "blockerId" = $1 ORDER BY "createdAt" DESC`, blockerID)
+
+	result, err := pgx.CollectRows(rows, scanBlockedUser(p, ctx))
+	if err != nil {
+		return err
+	}
+
+	b.Err = nil
+	b.Message = "Done!"
+	b.Result = result
+
+	return nil
+}
+
+// Blocks blockedID and, in the same transaction, removes follows and follow requests between the two users both ways.
+// Blocking someone already blocked changes nothing.
+func (b *BlockResponse) CreateBlock(p *pgxpool.Pool, ctx context.Context, blockerID, blockedID int) error {
+	x := &BlockedUser{UserID: blockedID}
+
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The no-op update makes RETURNING give back the original row on conflict
+	err = tx.QueryRow(ctx, `
+		INSERT INTO "UserBlock" ("blockerId", "blockedId") VALUES ($1, $2)
+		ON CONFLICT ("blockerId", "blockedId") DO UPDATE SET "blockerId" = EXCLUDED."blockerId"
+		RETURNING "createdAt"`, blockerID, blockedID).Scan(&x.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM ONLY "UserNetwork"
+		WHERE ("followerId" = $1 AND "followingId" = $2) OR ("followerId" = $2 AND "followingId" = $1)`, blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM ONLY "FollowRequest"
+		WHERE ("requesterId" = $1 AND "targetId" = $2) OR ("requesterId" = $2 AND "targetId" = $1)`, blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	author, err := FetchAuthor(p, ctx, blockedID)
+	if err != nil {
+		return err
+	}
+	x.User = *author
+
+	b.Err = nil
+	b.Message = "Done!"
+	b.Result = []*BlockedUser{x}
+
+	return nil
+}
+
+// Unblocking someone not blocked is not an error. Removed follows are not restored.
+func (b *BlockResponse) RemoveBlock(p *pgxpool.Pool, ctx context.Context, blockerID, blockedID int) error {
+	if _, err := p.Exec(ctx, `DELETE FROM ONLY "UserBlock" WHERE "blockerId" = $1 AND "blockedId" = $2`, blockerID, blockedID); err != nil {
+		return err
+	}
+
+	b.Err = nil
+	b.Message = "Done!"
+	b.Result = nil
+
+	return nil
+}
+
+// Users muterID muted, latest first
+func (b *BlockResponse) FetchMutes(p *pgxpool.Pool, ctx context.Context, muterID int) error {
+	rows, _ := p.Query(ctx, `SELECT "mutedId", "createdAt" FROM "UserMute" WHERE "muterId" = $1 ORDER BY "createdAt" DESC`, muterID)
+
+	result, err := pgx.CollectRows(rows, scanBlockedUser(p, ctx))
+	if err != nil {
+		return err
+	}
+
+	b.Err = nil
+	b.Message = "Done!"
+	b.Result = result
+
+	return nil
+}
+
+// Muting someone already muted changes nothing
+func (b *BlockResponse) CreateMute(p *pgxpool.Pool, ctx context.Context, muterID, mutedID int) error {
+	x := &BlockedUser{UserID: mutedID}
+
+	// The no-op update makes RETURNING give back the original row on conflict
+	err := p.QueryRow(ctx, `
+		INSERT INTO "UserMute" ("muterId", "mutedId") VALUES ($1, $2)
+		ON CONFLICT ("muterId", "mutedId") DO UPDATE SET "muterId" = EXCLUDED."muterId"
+		RETURNING "createdAt"`, muterID, mutedID).Scan(&x.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	author, err := FetchAuthor(p, ctx, mutedID)
+	if err != nil {
+		return err
+	}
+	x.User = *author
+
+	b.Err = nil
+	b.Message = "Done!"
+	b.Result = []*BlockedUser{x}
+
+	return nil
+}
+
+// Unmuting someone not muted is not an error
+func (b *BlockResponse) RemoveMute(p *pgxpool.Pool, ctx context.Context, muterID, mutedID int) error {
+	if _, err := p.Exec(ctx, `DELETE FROM ONLY "UserMute" WHERE "muterId" = $1 AND "mutedId" = $2`, muterID, mutedID); err != nil {
+		return err
+	}
+
+	b.Err = nil
+	b.Message = "Done!"
+	b.Result = nil
+
+	return nil
+}
+
+// Whether either of the two users blocked the other
+func IsBlocked(p *pgxpool.Pool, ctx context.Context, userID, otherID int) (bool, error) {
+	var blocked bool
+
+	err := p.QueryRow(ctx, `SELECT NOT `+notBlocked("$1::integer", "$2::integer"), userID, otherID).Scan(&blocked)
+
+	return blocked, err
+}
+
+func scanBlockedUser(p *pgxpool.Pool, ctx context.Context) pgx.RowToFunc[*BlockedUser] {
+	return func(row pgx.CollectableRow) (*BlockedUser, error) {
+		x := &BlockedUser{}
+
+		if err := row.Scan(&x.UserID, &x.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		author, err := FetchAuthor(p, ctx, x.UserID)
+		if err != nil {
+			return nil, err
+		}
+		x.User = *author
+
+		return x, nil
+	}
+}
+
+func (b *BlockRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	b.UserID = userID
+
+	if target := r.PathValue("userID"); target != "" {
+		num, err := strconv.ParseInt(target, 10, 0)
+		if err != nil {
+			return err
+		}
+		b.TargetID = int(num)
+	}
+
+	return nil
+}
+
+// --------------------- Utility Layer -------------------------- //
+
+// SQL condition that neither user blocked the other. Both arguments are SQL expressions of user ids.
+func notBlocked(userID, otherID string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+		SELECT 1 FROM "UserBlock" ub
+		WHERE (ub."blockerId" = %[1]s AND ub."blockedId" = %[2]s) OR (ub."blockerId" = %[2]s AND ub."blockedId" = %[1]s)
+	)`, userID, otherID)
+}
+
+// SQL condition that muterID did not mute authorID. Both arguments are SQL expressions of user ids.
+func notMuted(muterID, authorID string) string {
+	return fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM "UserMute" um WHERE um."muterId" = %s AND um."mutedId" = %s)`, muterID, authorID)
+}
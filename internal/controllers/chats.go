@@ -58,7 +58,10 @@ func (c *Controller) Chat(pool *pgxpool.Pool) http.HandlerFunc {
 func getMessage(p *pgxpool.Pool, ctx context.Context, id int) error {
 	defer p.Close()
 
-	rows, _ := p.Query(ctx, `SELECT * FROM "Messages" WHERE "roomId" = $1`, id)
+	viewerID, _ := UserFromContext(ctx)
+
+	// Messages of users in a block with the viewer are left out
+	rows, _ := p.Query(ctx, `SELECT * FROM "Messages" WHERE "roomId" = $1 AND `+notBlocked(`"authorId"`, "$2"), id, viewerID)
 
 	result, err := pgx.CollectRows(rows, scanMessage)
 	if err != nil {
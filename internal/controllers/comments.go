@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -16,16 +17,33 @@ import (
 )
 
 type Comment struct {
-	ID        int       `json:"id,omitzero"`
-	Path      string    `json:"path,omitzero"`
-	Depth     int       `json:"depth,omitzero"`
-	NumChild  int       `json:"numChild,omitzero"`
-	UpdatedAt time.Time `json:"updatedAt,omitzero"`
-	Message   string    `json:"message,omitzero"`
-	PostID    int       `json:"postID,omitzero"`
-	AuthorID  int       `json:"authorID,omitzero"`
-	IsDeleted bool      `json:"isDeleted,omitzero"`
-	CreatedAt time.Time `json:"createdAt,omitzero"`
+	ID        int        `json:"id,omitzero"`
+	Path      string     `json:"path,omitzero"`
+	Depth     int        `json:"depth,omitzero"`
+	NumChild  int        `json:"numChild,omitzero"`
+	UpdatedAt time.Time  `json:"updatedAt,omitzero"`
+	Message   string     `json:"message,omitzero"`
+	PostID    int        `json:"postID,omitzero"`
+	AuthorID  int        `json:"authorID,omitzero"`
+	Author    Author     `json:"author,omitzero"`
+	Mentions  []*Mention `json:"mentions,omitzero"`
+	IsDeleted bool       `json:"isDeleted,omitzero"`
+	Edited    bool       `json:"edited,omitzero"`
+	CreatedAt time.Time  `json:"createdAt,omitzero"`
+
+	Reactions  []*ReactionCount `json:"reactions,omitzero"`
+	MyReaction int              `json:"myReaction,omitzero"` // reactID of the caller's reaction
+}
+
+type CommentRevision struct {
+	Message  string    `json:"message,omitzero"`
+	EditedAt time.Time `json:"editedAt,omitzero"`
+}
+
+type CommentRevisionResponse struct {
+	Err     error              `json:"err,omitzero"`
+	Message string             `json:"message,omitzero"`
+	Result  []*CommentRevision `json:"result"`
 }
 type CommentResponse struct {
 	Err     error      `json:"err,omitzero"`
@@ -68,6 +86,8 @@ func (c *Controller) Comment(pool *pgxpool.Pool) http.HandlerFunc {
 			response, err = params.DelComment(pool, r.Context())
 		case http.MethodPost:
 			response, err = params.PostReply(pool, r.Context())
+		case http.MethodPut:
+			response, err = params.PutComment(pool, r.Context())
 		default:
 			wr.WriteHeader(http.StatusMethodNotAllowed)
 			return
@@ -91,6 +111,34 @@ func (c *Controller) Comment(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+// Handles the previous messages of an edited comment
+func (c *Controller) CommentRevisions(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &CommentRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.GetCommentRevisions(pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
 // --------------------- Service Layer -------------------------- //
 
 func (c *CommentRequest) GetComment(p *pgxpool.Pool, ctx context.Context) (*CommentResponse, error) {
@@ -128,100 +176,98 @@ func (c *CommentRequest) DelComment(p *pgxpool.Pool, ctx context.Context) (*Comm
 	return response, nil
 }
 
-// Used in DynamicPostController() at posts.go
-func (c *PostRequest) PostComment(p *pgxpool.Pool, ctx context.Context) (*CommentResponse, error) {
+// Replaces the message of a comment. Only its author can edit it, and only within the edit window.
+func (c *CommentRequest) PutComment(p *pgxpool.Pool, ctx context.Context) (*CommentResponse, error) {
 	response := &CommentResponse{}
 
-	c.Message = strings.TrimSpace(c.Message)
-	if c.Message == "" {
+	message := strings.TrimSpace(c.Message)
+	if message == "" {
 		return nil, errors.New("message is empty")
 	}
 
-	if c.PostID == 0 || c.AuthorID == 0 {
+	if c.PostID == 0 || c.CommentID == 0 || c.AuthorID == 0 {
 		return nil, errors.New("bad request body")
 	}
 
-	// Find the latest top-level comment to a post (aka not a subcomment)
-	rootPath, rootErr := findLastRootComment(p, ctx)
-	var pathErr error
+	window, err := commentEditWindow()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := response.UpdateComment(p, ctx, c.CommentID, c.PostID, c.AuthorID, message, time.Now().Add(-window)); err != nil {
+		return nil, err
+	}
 
-	switch rootErr {
-	case pgx.ErrNoRows:
-		// Start a root path of "0001" if no pre-existing top-level comments
-		rootPath, pathErr = customUtil.ConvertIntToPath(1)
-	case nil:
-		// increment by 1
-		rootPath, pathErr = customUtil.IncrementPath(rootPath)
-	default:
-		// return unexpected root errors
-		return nil, rootErr
+	comment := response.Result[0]
+	if comment.Mentions, err = SetMentions(p, ctx, c.AuthorID, 0, comment.ID, message); err != nil {
+		return nil, err
 	}
 
-	if pathErr != nil {
-		return nil, pathErr
+	return response, nil
+}
+
+func (c *CommentRequest) GetCommentRevisions(p *pgxpool.Pool, ctx context.Context) (*CommentRevisionResponse, error) {
+	response := &CommentRevisionResponse{}
+
+	if c.PostID == 0 || c.CommentID == 0 {
+		return nil, errors.New("bad request body")
 	}
 
-	if dbErr := response.CreateComment(p, ctx, c.PostID, c.AuthorID, c.Message, rootPath); dbErr != nil {
-		return nil, dbErr
+	if err := response.FetchCommentRevisions(p, ctx, c.CommentID, c.PostID); err != nil {
+		return nil, err
 	}
 
 	return response, nil
 }
 
-func (c *CommentRequest) PostReply(p *pgxpool.Pool, ctx context.Context) (*CommentResponse, error) {
+// Used in DynamicPostController() at posts.go
+func (c *PostRequest) PostComment(p *pgxpool.Pool, ctx context.Context) (*CommentResponse, error) {
 	response := &CommentResponse{}
-	var newPath string
 
-	message := strings.TrimSpace(c.Message)
-	if message == "" {
+	c.Message = strings.TrimSpace(c.Message)
+	if c.Message == "" {
 		return nil, errors.New("message is empty")
 	}
 
-	if c.AuthorID == 0 || c.CommentID == 0 {
+	if c.PostID == 0 || c.AuthorID == 0 {
 		return nil, errors.New("bad request body")
 	}
 
-	// Get the root path as base to reply path, depth and numChild to increment by 1
-	root, err := findRootPathBaseNumChild(p, ctx, c.CommentID, c.PostID)
-	if err != nil {
+	// The path is allocated inside the insert's transaction so concurrent comments cannot collide
+	if err := response.CreateComment(p, ctx, c.PostID, c.AuthorID, c.Message); err != nil {
 		return nil, err
 	}
 
-	// Start a path if no subcomments
-	if root.NumChild == 0 {
-		newPath, err = customUtil.ConvertIntToPath(1)
-		if err != nil {
-			return nil, err
-		}
-
-		// append "0001" to root path
-		newPath = root.Path + newPath
+	comment := response.Result[0]
+	mentions, err := SetMentions(p, ctx, c.AuthorID, 0, comment.ID, c.Message)
+	if err != nil {
+		return nil, err
+	}
+	comment.Mentions = mentions
 
-	} else {
-		newPath, err = findLastChildComment(p, ctx, root.Depth, root.NumChild, root.Path)
-		if err != nil {
-			return nil, err
-		}
+	return response, nil
+}
 
-		// newPath already consists of parent path
-		newPath, err = customUtil.IncrementPath(newPath)
-		if err != nil {
-			return nil, err
-		}
+func (c *CommentRequest) PostReply(p *pgxpool.Pool, ctx context.Context) (*CommentResponse, error) {
+	response := &CommentResponse{}
 
+	message := strings.TrimSpace(c.Message)
+	if message == "" {
+		return nil, errors.New("message is empty")
 	}
 
-	// Update the numChild of the root comment
-	incrementNumChild, err := updateNumChild(p, ctx, root.NumChild+1, c.CommentID, root.Path)
-	if err != nil {
-		return nil, err
+	if c.AuthorID == 0 || c.CommentID == 0 {
+		return nil, errors.New("bad request body")
 	}
 
-	if incrementNumChild != root.NumChild+1 {
-		return nil, errors.New("root numchild not updated")
+	// The parent comment is locked while its numchild is bumped, so concurrent replies get distinct paths
+	if err := response.CreateReply(p, ctx, c.PostID, c.CommentID, c.AuthorID, message); err != nil {
+		return nil, err
 	}
 
-	if err := response.CreateReply(p, ctx, c.PostID, c.AuthorID, root.Depth+1, message, newPath); err != nil {
+	var err error
+	reply := response.Result[0]
+	if reply.Mentions, err = SetMentions(p, ctx, c.AuthorID, 0, reply.ID, message); err != nil {
 		return nil, err
 	}
 
@@ -233,13 +279,19 @@ func (c *CommentRequest) PostReply(p *pgxpool.Pool, ctx context.Context) (*Comme
 func (c *CommentResponse) FetchComment(p *pgxpool.Pool, ctx context.Context, id, postID int, getReplies bool) error {
 	x := &Comment{}
 
-	// Consider order of columns as it appears on db
-	err := p.QueryRow(ctx, `SELECT * FROM "Comment" WHERE id = $1 AND "postId" = $2`, id, postID).Scan(
+	viewerID, _ := UserFromContext(ctx)
+
+	// Consider order of columns as it appears on db. Comments of users in a block with the viewer, and
+	// comments on posts the viewer may not see, are not found.
+	err := p.QueryRow(ctx, `
+		SELECT c.* FROM "Comment" c JOIN "Post" p ON p."id" = c."postId"
+		WHERE c.id = $1 AND c."postId" = $2 AND `+notBlocked(`c."authorId"`, "$3")+` AND `+postVisible("p", "$3"),
+		id, postID, viewerID).Scan(
 		&x.ID,
 		&x.Path,
 		&x.Depth,
 		&x.NumChild,
-		nil, // ignore some column
+		&x.CreatedAt,
 		&x.UpdatedAt,
 		&x.Message,
 		&x.PostID,
@@ -250,6 +302,21 @@ func (c *CommentResponse) FetchComment(p *pgxpool.Pool, ctx context.Context, id,
 	if err != nil {
 		return err
 	}
+	x.Edited = x.UpdatedAt.After(x.CreatedAt)
+
+	author, err := FetchAuthor(p, ctx, x.AuthorID)
+	if err != nil {
+		return err
+	}
+	x.Author = *author
+
+	if x.Mentions, err = GetMentions(p, ctx, 0, x.ID); err != nil {
+		return err
+	}
+
+	if x.Reactions, x.MyReaction, err = GetCommentReactions(p, ctx, x.ID); err != nil {
+		return err
+	}
 
 	// logger := customUtil.NewCustomLogger()
 
@@ -283,7 +350,7 @@ func (c *CommentResponse) RemoveComment(p *pgxpool.Pool, ctx context.Context, id
 		&x.Path,
 		&x.Depth,
 		&x.NumChild,
-		nil, // ignore some column
+		&x.CreatedAt,
 		&x.UpdatedAt,
 		&x.Message,
 		&x.PostID,
@@ -294,6 +361,7 @@ func (c *CommentResponse) RemoveComment(p *pgxpool.Pool, ctx context.Context, id
 	if err != nil {
 		return err
 	}
+	x.Edited = x.UpdatedAt.After(x.CreatedAt)
 
 	c.Err = nil
 	c.Message = "Done!"
@@ -316,14 +384,20 @@ func (c *CommentResponse) FetchReply(p *pgxpool.Pool, ctx context.Context, id in
 		return nil
 	}
 
-	// 4-character path ranges from "0000" to "ZZZZ"
-	gtPath := root.Path + customUtil.MinSegment
-	ltePath := root.Path + customUtil.MaxSegment
+	viewerID, _ := UserFromContext(ctx)
 
-	// Get all comments under the top-level comment (aka whose depth is greater and has root path of that top-level comment)
-	rows, _ := p.Query(ctx, `SELECT * FROM "Comment" WHERE depth > $1 AND "postId" = $2 AND path BETWEEN $3 AND $4 ORDER BY path`, root.Depth, postID, gtPath, ltePath)
+	// Get all comments under the top-level comment (aka whose depth is greater and whose path starts with the root path).
+	// Segments only hold 0-9A-Z, so the root path never needs escaping inside LIKE.
+	// Replies below a comment of a user in a block with the viewer are left out with it.
+	rows, _ := p.Query(ctx, `
+		SELECT * FROM "Comment" c WHERE c.depth > $1 AND c."postId" = $2 AND c.path LIKE $3 || '%'
+		AND NOT EXISTS (
+			SELECT 1 FROM "Comment" a
+			WHERE a."postId" = $2 AND a.depth > $1 AND c.path LIKE a.path || '%' AND NOT `+notBlocked(`a."authorId"`, "$4")+`
+		)
+		ORDER BY c.path`, root.Depth, postID, root.Path, viewerID)
 
-	result, err := pgx.CollectRows(rows, scanComment)
+	result, err := pgx.CollectRows(rows, scanComment(p, ctx))
 	if err != nil {
 		return err
 	}
@@ -340,14 +414,25 @@ func (c *CommentResponse) FetchReply(p *pgxpool.Pool, ctx context.Context, id in
 	return nil
 }
 
-func (c *CommentResponse) CreateComment(p *pgxpool.Pool, ctx context.Context, postID, authorID int, message, rootPath string) error {
+func (c *CommentResponse) CreateComment(p *pgxpool.Pool, ctx context.Context, postID, authorID int, message string) error {
 	var (
 		comment   = &Comment{}
 		createdAt = time.Now()
 	)
 
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	postAuthorID, rootPath, err := allocateRootPath(tx, ctx, postID, authorID)
+	if err != nil {
+		return err
+	}
+
 	// Insert a new row and fetch the autoincremented id
-	err := p.QueryRow(
+	err = tx.QueryRow(
 		ctx,
 		`INSERT INTO "Comment" ("path", "depth", "createdAt", "updatedAt", "message", "postId", "authorId") VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
 		rootPath, 1, createdAt, createdAt, message, postID, authorID,
@@ -357,6 +442,18 @@ func (c *CommentResponse) CreateComment(p *pgxpool.Pool, ctx context.Context, po
 		return err
 	}
 
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	// Tell the post author someone commented. Keyed on the post alone so comments on it group together.
+	if err = Notify(p, ctx, &Notification{UserID: postAuthorID, ActorID: authorID, Type: customUtil.NOTIFICATION_COMMENT, PostID: postID}); err != nil {
+		return err
+	}
+
+	comment.Path = rootPath
+	comment.Depth = 1
+
 	c.Result = []*Comment{comment}
 	c.Err = nil
 	c.Message = "Done!"
@@ -364,31 +461,159 @@ func (c *CommentResponse) CreateComment(p *pgxpool.Pool, ctx context.Context, po
 	return nil
 }
 
-func (c *CommentResponse) CreateReply(p *pgxpool.Pool, ctx context.Context, postID, authorID, depth int, message, path string) error {
+func (c *CommentResponse) CreateReply(p *pgxpool.Pool, ctx context.Context, postID, parentID, authorID int, message string) error {
 	var (
-		err   error
-		reply = &Comment{}
-
+		reply     = &Comment{}
 		createdAt = time.Now()
 	)
 
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	parent, path, err := allocateReplyPath(tx, ctx, parentID, postID, authorID)
+	if err != nil {
+		return err
+	}
+
 	// Insert a new row and fetch the autoincremented id
-	err = p.QueryRow(
+	err = tx.QueryRow(
 		ctx,
 		`INSERT INTO "Comment" ("path", "depth", "createdAt", "updatedAt", "message", "postId", "authorId") VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
-		path, depth, createdAt, createdAt, message, postID, authorID,
+		path, parent.Depth+1, createdAt, createdAt, message, postID, authorID,
 	).Scan(&reply.ID)
 
 	if err != nil {
 		return err
 	}
 
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	// Tell the author of the parent comment someone replied. Keyed on the parent so replies to it group together.
+	if err = Notify(p, ctx, &Notification{UserID: parent.AuthorID, ActorID: authorID, Type: customUtil.NOTIFICATION_REPLY, PostID: postID, CommentID: parent.ID}); err != nil {
+		return err
+	}
+
+	reply.Path = path
+	reply.Depth = parent.Depth + 1
+
 	c.Result = []*Comment{reply}
 	c.Err = nil
 	c.Message = "Done!"
 	return nil
 }
 
+// Keeps the current message as a revision and replaces it. Comments created before editableSince are locked.
+func (c *CommentResponse) UpdateComment(p *pgxpool.Pool, ctx context.Context, id, postID, authorID int, message string, editableSince time.Time) error {
+	var (
+		x         = &Comment{}
+		previous  string
+		updatedAt = time.Now()
+	)
+
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the row so concurrent edits are kept as separate revisions
+	err = tx.QueryRow(ctx, `SELECT "message", "authorId", "createdAt", "isDeleted" FROM "Comment" WHERE id = $1 AND "postId" = $2 FOR UPDATE`, id, postID).Scan(
+		&previous,
+		&x.AuthorID,
+		&x.CreatedAt,
+		&x.IsDeleted,
+	)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case x.AuthorID != authorID:
+		return errors.New("only the author can edit a comment")
+	case x.IsDeleted:
+		return errors.New("cannot edit a deleted comment")
+	case x.CreatedAt.Before(editableSince):
+		return errors.New("comment can no longer be edited")
+	}
+
+	if previous == message {
+		return errors.New("message is unchanged")
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO "CommentRevision" ("commentId", "message", "editedAt") VALUES ($1, $2, $3)`, id, previous, updatedAt); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(ctx, `UPDATE "Comment" SET "message" = $1, "updatedAt" = $2 WHERE id = $3 RETURNING *`, message, updatedAt, id).Scan(
+		&x.ID,
+		&x.Path,
+		&x.Depth,
+		&x.NumChild,
+		&x.CreatedAt,
+		&x.UpdatedAt,
+		&x.Message,
+		&x.PostID,
+		&x.AuthorID,
+		&x.IsDeleted,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	author, err := FetchAuthor(p, ctx, x.AuthorID)
+	if err != nil {
+		return err
+	}
+	x.Author = *author
+	x.Edited = true
+
+	c.Result = []*Comment{x}
+	c.Err = nil
+	c.Message = "Done!"
+
+	return nil
+}
+
+// Previous messages of a comment, newest first. Deleted comments do not expose their history, and
+// neither do comments the viewer could not read in the thread itself.
+func (c *CommentRevisionResponse) FetchCommentRevisions(p *pgxpool.Pool, ctx context.Context, id, postID int) error {
+	viewerID, _ := UserFromContext(ctx)
+
+	rows, _ := p.Query(ctx, `
+		SELECT r."message", r."editedAt" FROM "CommentRevision" r
+		JOIN "Comment" c ON c."id" = r."commentId"
+		JOIN "Post" p ON p."id" = c."postId"
+		WHERE c."id" = $1 AND c."postId" = $2 AND NOT c."isDeleted" AND `+notBlocked(`c."authorId"`, "$3")+` AND `+postVisible("p", "$3")+`
+		ORDER BY r."editedAt" DESC, r."id" DESC`, id, postID, viewerID)
+
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*CommentRevision, error) {
+		x := &CommentRevision{}
+		if err := row.Scan(&x.Message, &x.EditedAt); err != nil {
+			return nil, err
+		}
+		return x, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	c.Err = nil
+	c.Message = "Done!"
+	c.Result = result
+
+	return nil
+}
+
 // Used at posts.go
 func GetCommentCount(p *pgxpool.Pool, ctx context.Context, postID int) (int, error) {
 	var count int
@@ -438,74 +663,117 @@ func (c *CommentRequest) Parse(r *http.Request) error {
 
 // --------------------- Utility Layer -------------------------- //
 
-func findLastChildComment(p *pgxpool.Pool, ctx context.Context, depth, numChild int, path string) (string, error) {
-	var childPath string
-
-	// 4-character path ranges from "0000" to "ZZZZ"
-	gtPath := path + customUtil.MinSegment
-	ltePath := path + customUtil.MaxSegment
-
-	err := p.QueryRow(ctx, `SELECT path FROM "Comment" WHERE depth > $1 AND path BETWEEN $2 AND $3 ORDER BY path DESC`, depth, gtPath, ltePath).Scan(&childPath)
-	if err != nil && errors.Is(err, pgx.ErrNoRows) && numChild > 0 {
-		return "", errors.New("cannot retrieve subcomments despite root.numchild > 0")
-	} else if err != nil {
-		return "", err
+// How long after posting a comment can still be edited
+func commentEditWindow() (time.Duration, error) {
+	window, ok := os.LookupEnv("COMMENT_EDIT_WINDOW")
+	if !ok {
+		return customUtil.COMMENT_EDIT_WINDOW, nil
 	}
 
-	return childPath, nil
+	return time.ParseDuration(window)
 }
 
-func findRootPathBaseNumChild(p *pgxpool.Pool, ctx context.Context, id, postID int) (*Comment, error) {
-	root := &Comment{}
+// Locks the post row so top-level comments of the same post are numbered one at a time.
+// Returns the post author and the path following the post's last top-level comment.
+// Posts that authorID may not see are not found.
+func allocateRootPath(tx pgx.Tx, ctx context.Context, postID, authorID int) (int, string, error) {
+	var (
+		postAuthorID int
+		lastPath     string
+	)
 
-	err := p.QueryRow(ctx, `SELECT path, depth, numchild FROM "Comment" WHERE id = $1 AND "postId" = $2`, id, postID).Scan(&root.Path, &root.Depth, &root.NumChild)
+	err := tx.QueryRow(ctx, `SELECT p."authorId" FROM "Post" p WHERE p.id = $1 AND `+postVisible("p", "$2")+` FOR UPDATE`, postID, authorID).Scan(&postAuthorID)
 	if err != nil {
-		return nil, err
+		return 0, "", err
 	}
 
-	return root, nil
+	// top-level comments have depth of 1
+	err = tx.QueryRow(ctx, `SELECT path FROM "Comment" WHERE "postId" = $1 AND depth = 1 ORDER BY path DESC LIMIT 1`, postID).Scan(&lastPath)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		// Start a root path of "11" (the encoding of 1) if no pre-existing top-level comments
+		path, err := customUtil.ConvertIntToPath(1)
+		return postAuthorID, path, err
+	case err != nil:
+		return 0, "", err
+	}
+
+	path, err := customUtil.IncrementPath(lastPath)
+	return postAuthorID, path, err
 }
 
-func updateNumChild(p *pgxpool.Pool, ctx context.Context, numChild, id int, path string) (int, error) {
-	var incrementNumChild int
-	err := p.QueryRow(ctx, `UPDATE "Comment" SET numchild = $1 WHERE id = $2 AND path = $3 RETURNING numchild`, numChild, id, path).Scan(&incrementNumChild)
+// Locks the parent comment and bumps its numchild, which doubles as the counter of its reply paths.
+// Soft-deleted replies keep their rows, so numchild never goes back and a path is never handed out twice.
+// Parents on posts that authorID may not see are not found.
+func allocateReplyPath(tx pgx.Tx, ctx context.Context, parentID, postID, authorID int) (*Comment, string, error) {
+	parent := &Comment{ID: parentID}
+
+	err := tx.QueryRow(ctx, `
+		SELECT c.path, c.depth, c.numchild, c."authorId" FROM "Comment" c JOIN "Post" p ON p."id" = c."postId"
+		WHERE c.id = $1 AND c."postId" = $2 AND `+postVisible("p", "$3")+`
+		FOR UPDATE OF c`, parentID, postID, authorID).Scan(
+		&parent.Path,
+		&parent.Depth,
+		&parent.NumChild,
+		&parent.AuthorID,
+	)
 	if err != nil {
-		return 0, err
+		return nil, "", err
 	}
-	return incrementNumChild, nil
-}
-
-func findLastRootComment(p *pgxpool.Pool, ctx context.Context) (string, error) {
-	var path string
 
-	// top-level comments have depth of 1
-	err := p.QueryRow(ctx, `SELECT path FROM "Comment" WHERE depth = 1 ORDER BY path DESC`).Scan(&path)
+	segment, err := customUtil.ConvertIntToPath(parent.NumChild + 1)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	return path, nil
+	if _, err := tx.Exec(ctx, `UPDATE "Comment" SET numchild = numchild + 1 WHERE id = $1`, parentID); err != nil {
+		return nil, "", err
+	}
+
+	return parent, parent.Path + segment, nil
 }
 
-func scanComment(row pgx.CollectableRow) (*Comment, error) {
-	x := &Comment{}
+func scanComment(p *pgxpool.Pool, ctx context.Context) pgx.RowToFunc[*Comment] {
+	return func(row pgx.CollectableRow) (*Comment, error) {
+		x := &Comment{}
+
+		// Consider order of columns as it appears on db
+		err := row.Scan(
+			&x.ID,
+			&x.Path,
+			&x.Depth,
+			&x.NumChild,
+			&x.CreatedAt,
+			&x.UpdatedAt,
+			&x.Message,
+			&x.PostID,
+			&x.AuthorID,
+			&x.IsDeleted,
+		)
+		if err != nil {
+			return x, err
+		}
 
-	// Consider order of columns as it appears on db
-	err := row.Scan(
-		&x.ID,
-		&x.Path,
-		&x.Depth,
-		&x.NumChild,
-		nil, // ignore a column
-		&x.UpdatedAt,
-		&x.Message,
-		&x.PostID,
-		&x.AuthorID,
-		&x.IsDeleted,
-	)
-	if err != nil {
-		return x, err
-	}
+		// Only edits move "updatedAt" past "createdAt"
+		x.Edited = x.UpdatedAt.After(x.CreatedAt)
+
+		// Query the author details of a comment
+		author, err := FetchAuthor(p, ctx, x.AuthorID)
+		if err != nil {
+			return nil, err
+		}
+		x.Author = *author
 
-	return x, nil
+		// Query the resolved @mentions of a comment
+		if x.Mentions, err = GetMentions(p, ctx, 0, x.ID); err != nil {
+			return nil, err
+		}
+
+		// Query the reactions of a comment per type
+		if x.Reactions, x.MyReaction, err = GetCommentReactions(p, ctx, x.ID); err != nil {
+			return nil, err
+		}
+
+		return x, nil
+	}
 }
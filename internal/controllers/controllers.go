@@ -2,9 +2,12 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	auth "github.com/app-clone-tod-auth"
@@ -57,6 +60,38 @@ var BaseChain = Chain{AcceptJSON, AddTimeoutLimit}
 // Used for endpoints requiring logged-in userID
 var PrivateChain = append(BaseChain, GetUser)
 
+// Used for public endpoints that show more to logged-in users
+var PublicChain = slices.Concat(BaseChain, Chain{GetOptionalUser})
+
+// Used for endpoints only admins may call
+func AdminChain(pool *pgxpool.Pool) Chain {
+	return slices.Concat(PrivateChain, Chain{RequireAdmin(pool)})
+}
+
+// Returns forbidden unless the userID added by GetUser belongs to an admin
+func RequireAdmin(pool *pgxpool.Pool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			var isAdmin bool
+
+			userID, _ := UserFromContext(r.Context())
+			if err := pool.QueryRow(r.Context(), `SELECT "isAdmin" FROM "User" WHERE "id" = $1`, userID).Scan(&isAdmin); err != nil {
+				fmt.Printf("error (internal): %s\n", err.Error())
+				wr.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if !isAdmin {
+				fmt.Printf("error (auth): user %d is not an admin\n", userID)
+				wr.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(wr, r)
+		})
+	}
+}
+
 // Appends userID from a jwt to client request.
 // Returns unauthorized if token is malformed, missing, etc.
 func GetUser(next http.Handler) http.Handler {
@@ -84,6 +119,17 @@ func GetUser(next http.Handler) http.Handler {
 	})
 }
 
+// Same as GetUser, except that anonymous clients carry on without a userID
+func GetOptionalUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		if id, err := auth.GetCookieWithToken(r); err == nil && id != 0 {
+			r = r.WithContext(NewUserContext(r.Context(), id))
+		}
+
+		next.ServeHTTP(wr, r)
+	})
+}
+
 func AddTimeoutLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
 		var (
@@ -131,16 +177,56 @@ func AcceptFormURLEncoded(next http.Handler) http.Handler {
 type Author struct {
 	FirstName string `json:"firstName,omitzero"`
 	LastName  string `json:"lastName,omitzero"`
+	AvatarURL string `json:"avatarUrl,omitzero"`
 }
 
 // repository-layer function used by several Controller methods
 func FetchAuthor(p *pgxpool.Pool, ctx context.Context, authorID int) (*Author, error) {
 	x := &Author{}
 
-	err := p.QueryRow(context.Background(), `SELECT "firstName","lastName" FROM "Profile" WHERE "userId" = $1`, authorID).Scan(&x.FirstName, &x.LastName)
+	err := p.QueryRow(context.Background(), `SELECT "firstName","lastName", COALESCE("profileUrl", '') FROM "Profile" WHERE "userId" = $1`, authorID).Scan(&x.FirstName, &x.LastName, &x.AvatarURL)
 	if err != nil {
 		return x, err
 	}
 
 	return x, nil
 }
+
+// Reads an optional int query parameter, falling back when it is missing
+func parseIntQuery(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	num, err := strconv.ParseInt(value, 10, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(num), nil
+}
+
+// Reads the optional "limit" query parameter, clamped to customUtil.MAX_PAGE_SIZE
+func parseLimit(r *http.Request) (int, error) {
+	limit := r.URL.Query().Get("limit")
+	if limit == "" {
+		return customUtil.DEFAULT_PAGE_SIZE, nil
+	}
+
+	num, err := strconv.ParseInt(limit, 10, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if num <= 0 {
+		return 0, errors.New("limit must be positive")
+	}
+
+	return min(int(num), customUtil.MAX_PAGE_SIZE), nil
+}
+
+// Escapes the LIKE wildcards of s so it only matches literally (backslash is the default LIKE escape)
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
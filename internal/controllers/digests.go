@@ -0,0 +1,577 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	textTemplate "text/template"
+	"time"
+
+	auth "github.com/app-clone-tod-auth"
+	mailer "github.com/app-clone-tod-mailer"
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed templates/digest.*.tmpl templates/unsubscribe.html.tmpl
+var digestTemplates embed.FS
+
+var (
+	digestHTML      = htmlTemplate.Must(htmlTemplate.ParseFS(digestTemplates, "templates/digest.html.tmpl"))
+	digestText      = textTemplate.Must(textTemplate.ParseFS(digestTemplates, "templates/digest.txt.tmpl"))
+	unsubscribePage = htmlTemplate.Must(htmlTemplate.ParseFS(digestTemplates, "templates/unsubscribe.html.tmpl"))
+)
+
+type DigestRequest struct {
+	UserID    int    `json:"userID,omitzero"`
+	Email     string `json:"email,omitzero"`
+	Frequency string `json:"frequency,omitzero"`
+}
+
+type DigestResponse struct {
+	Message string          `json:"message,omitzero"`
+	Err     error           `json:"err,omitzero"`
+	Result  *DigestSettings `json:"result,omitzero"`
+}
+
+type DigestSettings struct {
+	Email        string     `json:"email,omitzero"`
+	Verified     bool       `json:"verified"` // digests are only mailed once the address is confirmed
+	Frequency    string     `json:"frequency,omitzero"`
+	LastDigestAt *time.Time `json:"lastDigestAt,omitempty"`
+}
+
+// Content rendered into templates/digest.*.tmpl
+type Digest struct {
+	Name           string
+	Frequency      string
+	Since          time.Time
+	Notifications  []*NotificationGroup
+	Followers      []*ProfileNetwork
+	Posts          []*Post
+	AppURL         string
+	UnsubscribeURL string
+}
+
+// A user due a digest
+type digestUser struct {
+	id           int
+	email        string
+	frequency    string
+	lastDigestAt *time.Time
+}
+
+// Handles the caller's digest email and frequency. A new email is sent a confirmation link through m.
+func (c *Controller) DigestSettings(pool *pgxpool.Pool, m mailer.Mailer) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		var response *DigestResponse
+		var err error
+
+		params := &DigestRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			response, err = params.GetDigestSettings(pool, r.Context())
+		case http.MethodPut:
+			response, err = params.PutDigestSettings(pool, r.Context(), m)
+		default:
+			wr.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// Handles the unsubscribe link of digest emails. The link is signed, so it works without being logged in.
+// GET only shows a confirmation that posts back, so link scanners opening it change nothing.
+// POST unsubscribes, and is also what mail clients send for the RFC 8058 one-click variant.
+func (c *Controller) Unsubscribe(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if err := auth.VerifySignedValues(query); err != nil || query.Get("action") != "unsubscribe" {
+			fmt.Printf("error (auth): invalid unsubscribe link\n")
+			wr.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			var page bytes.Buffer
+			if err := unsubscribePage.Execute(&page, r.URL.RequestURI()); err != nil {
+				fmt.Printf("error (internal): %s\n", err.Error())
+				wr.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+			wr.Write(page.Bytes())
+			return
+		case http.MethodPost:
+		default:
+			wr.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := strconv.ParseInt(query.Get("user"), 10, 0)
+		if err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response := &DigestResponse{}
+		if err := response.UpdateDigestSettings(pool, r.Context(), int(userID), customUtil.DIGEST_OFF); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// Handles the link of confirmation emails sent by DigestSettings.
+// The link is signed, so it works without being logged in.
+func (c *Controller) VerifyEmail(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if err := auth.VerifySignedValues(query); err != nil || query.Get("action") != "verify_email" {
+			fmt.Printf("error (auth): invalid email verification link\n")
+			wr.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := strconv.ParseInt(query.Get("user"), 10, 0)
+		if err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response := &DigestResponse{}
+		err = response.ConfirmEmail(pool, r.Context(), int(userID), query.Get("email"), time.Now())
+		if errors.Is(err, errEmailChanged) {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusConflict)
+			return
+		} else if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// Sends every due digest each customUtil.DIGEST_INTERVAL until ctx is done
+func RunDigests(ctx context.Context, pool *pgxpool.Pool, m mailer.Mailer) {
+	ticker := time.NewTicker(customUtil.DIGEST_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if err := SendDueDigests(ctx, pool, m, time.Now()); err != nil {
+			fmt.Printf("error (digest): %s\n", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (d *DigestRequest) GetDigestSettings(p *pgxpool.Pool, ctx context.Context) (*DigestResponse, error) {
+	response := &DigestResponse{}
+
+	if err := response.FetchDigestSettings(p, ctx, d.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (d *DigestRequest) PutDigestSettings(p *pgxpool.Pool, ctx context.Context, m mailer.Mailer) (*DigestResponse, error) {
+	response := &DigestResponse{}
+
+	if d.Email == "" && d.Frequency == "" {
+		return nil, errors.New("bad request body")
+	}
+
+	if d.Email != "" {
+		if err := response.UpdateEmail(p, ctx, m, d.UserID, d.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.Frequency != "" {
+		if err := response.UpdateDigestSettings(p, ctx, d.UserID, d.Frequency); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := response.FetchDigestSettings(p, ctx, d.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Sends a digest to every user whose last digest is older than their frequency.
+// Users with nothing new are skipped but still marked, so they are checked again next period.
+func SendDueDigests(ctx context.Context, p *pgxpool.Pool, m mailer.Mailer, now time.Time) error {
+	users, err := fetchDueDigestUsers(p, ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		// One failing address should not hold back everyone else
+		if err := sendDigest(ctx, p, m, user, now); err != nil {
+			fmt.Printf("error (digest): user %d: %s\n", user.id, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func sendDigest(ctx context.Context, p *pgxpool.Pool, m mailer.Mailer, user *digestUser, now time.Time) error {
+	since := now.Add(-customUtil.DigestPeriods[user.frequency])
+	if user.lastDigestAt != nil {
+		since = *user.lastDigestAt
+	}
+
+	digest, err := buildDigest(p, ctx, user, since)
+	if err != nil {
+		return err
+	}
+
+	if len(digest.Notifications) > 0 || len(digest.Followers) > 0 || len(digest.Posts) > 0 {
+		message, err := renderDigest(digest, user.email)
+		if err != nil {
+			return err
+		}
+
+		if err := m.Send(ctx, message); err != nil {
+			return err
+		}
+	}
+
+	_, err = p.Exec(ctx, `
+		INSERT INTO "UserSettings" ("userId", "lastDigestAt") VALUES ($1, $2)
+		ON CONFLICT ("userId") DO UPDATE SET "lastDigestAt" = EXCLUDED."lastDigestAt"`,
+		user.id, now)
+
+	return err
+}
+
+func buildDigest(p *pgxpool.Pool, ctx context.Context, user *digestUser, since time.Time) (*Digest, error) {
+	notifications := &NotificationResponse{}
+	if err := notifications.FetchNotifications(p, ctx, user.id, 0, customUtil.DIGEST_ITEMS, true); err != nil {
+		return nil, err
+	}
+
+	followers := &ProfileNetworkResponse{}
+	query := `
+		SELECT n."followerId", n."assignedAt", COALESCE(pf."firstName", ''), COALESCE(pf."lastName", ''), COALESCE(pf."profileUrl", '')
+		FROM "UserNetwork" n LEFT JOIN "Profile" pf ON pf."userId" = n."followerId"
+		WHERE n."followingId" = $1 AND n."assignedAt" > $2
+		ORDER BY n."assignedAt" DESC
+		LIMIT $3`
+	if err := followers.FetchNetwork(p, ctx, query, user.id, since, customUtil.DIGEST_ITEMS); err != nil {
+		return nil, err
+	}
+
+	posts := &PostResponse{}
+	if err := posts.FetchTopFollowedPosts(p, ctx, user.id, since, customUtil.DIGEST_ITEMS); err != nil {
+		return nil, err
+	}
+
+	author, err := FetchAuthor(p, ctx, user.id)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	unsubscribeURL, err := unsubscribeLink(user.id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Digest{
+		Name:           author.FirstName,
+		Frequency:      user.frequency,
+		Since:          since,
+		Notifications:  notifications.Result,
+		Followers:      followers.Result,
+		Posts:          posts.Result,
+		AppURL:         os.Getenv("FRONTEND_URL"),
+		UnsubscribeURL: unsubscribeURL,
+	}, nil
+}
+
+func renderDigest(digest *Digest, to string) (*mailer.Message, error) {
+	var html, text bytes.Buffer
+
+	if err := digestHTML.Execute(&html, digest); err != nil {
+		return nil, err
+	}
+
+	if err := digestText.Execute(&text, digest); err != nil {
+		return nil, err
+	}
+
+	return &mailer.Message{
+		To:      to,
+		Subject: "Your " + digest.Frequency + " digest",
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + digest.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
+
+// Signed link that turns digests off for userID without logging in
+func unsubscribeLink(userID int) (string, error) {
+	serverURL, ok := os.LookupEnv("BASE_SERVER_URL")
+	if !ok {
+		return "", errors.New("environment variable not found")
+	}
+
+	query, err := auth.SignValues(url.Values{"action": {"unsubscribe"}, "user": {strconv.Itoa(userID)}})
+	if err != nil {
+		return "", err
+	}
+
+	return serverURL + "/unsubscribe/?" + query, nil
+}
+
+// Mails email a signed link confirming it belongs to userID
+func sendEmailVerification(ctx context.Context, m mailer.Mailer, userID int, email string, now time.Time) error {
+	serverURL, ok := os.LookupEnv("BASE_SERVER_URL")
+	if !ok {
+		return errors.New("environment variable not found")
+	}
+
+	query, err := auth.SignValues(url.Values{
+		"action":  {"verify_email"},
+		"user":    {strconv.Itoa(userID)},
+		"email":   {email},
+		"expires": {strconv.FormatInt(now.Add(customUtil.EMAIL_VERIFICATION_TTL).Unix(), 10)},
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.Send(ctx, &mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Text: fmt.Sprintf("Open this link to confirm digests should be sent to this address. It works until %s:\n\n%s\n",
+			now.Add(customUtil.EMAIL_VERIFICATION_TTL).UTC().Format("January 2, 2006 15:04 MST"), serverURL+"/verify-email/?"+query),
+	})
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+func (d *DigestResponse) FetchDigestSettings(p *pgxpool.Pool, ctx context.Context, userID int) error {
+	x := &DigestSettings{}
+
+	err := p.QueryRow(ctx, `
+		SELECT COALESCE(u."email", ''), u."emailVerifiedAt" IS NOT NULL, COALESCE(s."digestFrequency", $2), s."lastDigestAt"
+		FROM "User" u LEFT JOIN "UserSettings" s ON s."userId" = u."id"
+		WHERE u."id" = $1`,
+		userID, customUtil.DIGEST_DEFAULT_FREQUENCY,
+	).Scan(&x.Email, &x.Verified, &x.Frequency, &x.LastDigestAt)
+
+	if err != nil {
+		return err
+	}
+
+	d.Err = nil
+	d.Message = "Done!"
+	d.Result = x
+
+	return nil
+}
+
+// Replaces the address of userID with an unconfirmed email and mails it a confirmation link.
+// Giving the address again re-sends the link, unless it is confirmed already.
+func (d *DigestResponse) UpdateEmail(p *pgxpool.Pool, ctx context.Context, m mailer.Mailer, userID int, email string) error {
+	res, err := p.Exec(ctx, `
+		UPDATE "User" SET "email" = $1, "emailVerifiedAt" = NULL
+		WHERE "id" = $2 AND ("email" IS DISTINCT FROM $1 OR "emailVerifiedAt" IS NULL)`,
+		email, userID)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() > 0 {
+		if err := sendEmailVerification(ctx, m, userID, email, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	d.Err = nil
+	d.Message = "Done!"
+
+	return nil
+}
+
+// Confirms email as the address of userID, unless it was replaced since the link was sent
+func (d *DigestResponse) ConfirmEmail(p *pgxpool.Pool, ctx context.Context, userID int, email string, now time.Time) error {
+	res, err := p.Exec(ctx, `
+		UPDATE "User" SET "emailVerifiedAt" = COALESCE("emailVerifiedAt", $3)
+		WHERE "id" = $1 AND "email" = $2`,
+		userID, email, now)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() == 0 {
+		return errEmailChanged
+	}
+
+	return d.FetchDigestSettings(p, ctx, userID)
+}
+
+// Sets the digest frequency of userID. UpdateSettings at settings.go does the write, as for a settings PATCH.
+func (d *DigestResponse) UpdateDigestSettings(p *pgxpool.Pool, ctx context.Context, userID int, frequency string) error {
+	if err := (&SettingsResponse{}).UpdateSettings(p, ctx, userID, &SettingsPatch{DigestFrequency: &frequency}); err != nil {
+		return err
+	}
+
+	d.Err = nil
+	d.Message = "Done!"
+
+	return nil
+}
+
+// Published posts since the given time by users that userID follows and did not mute, most reacted and commented first
+func (pr *PostResponse) FetchTopFollowedPosts(p *pgxpool.Pool, ctx context.Context, userID int, since time.Time, limit int) error {
+	rows, _ := p.Query(ctx, `
+		SELECT p.* FROM "Post" p
+		JOIN "UserNetwork" n ON n."followingId" = p."authorId" AND n."followerId" = $1
+		WHERE p."published" = true AND p."isDeleted" = false AND p."createdAt" > $2 AND `+notMuted("$1", `p."authorId"`)+`
+		ORDER BY (SELECT COUNT(*) FROM "Reactions" r WHERE r."postId" = p."id") + (SELECT COUNT(*) FROM "Comment" c WHERE c."postId" = p."id") DESC, p."id" DESC
+		LIMIT $3`,
+		userID, since, limit)
+
+	result, err := pgx.CollectRows(rows, scanPost(p, ctx))
+	if err != nil {
+		return err
+	}
+
+	pr.Result = result
+	pr.Message = "Done!"
+	pr.Err = nil
+	return nil
+}
+
+func fetchDueDigestUsers(p *pgxpool.Pool, ctx context.Context, now time.Time) ([]*digestUser, error) {
+	rows, _ := p.Query(ctx, `
+		SELECT u."id", u."email", COALESCE(s."digestFrequency", $1), s."lastDigestAt"
+		FROM "User" u LEFT JOIN "UserSettings" s ON s."userId" = u."id"
+		WHERE u."email" IS NOT NULL AND u."emailVerifiedAt" IS NOT NULL
+		AND (
+			(COALESCE(s."digestFrequency", $1) = $2 AND (s."lastDigestAt" IS NULL OR s."lastDigestAt" <= $3))
+			OR (COALESCE(s."digestFrequency", $1) = $4 AND (s."lastDigestAt" IS NULL OR s."lastDigestAt" <= $5))
+		)`,
+		customUtil.DIGEST_DEFAULT_FREQUENCY,
+		customUtil.DIGEST_DAILY, now.Add(-customUtil.DigestPeriods[customUtil.DIGEST_DAILY]),
+		customUtil.DIGEST_WEEKLY, now.Add(-customUtil.DigestPeriods[customUtil.DIGEST_WEEKLY]),
+	)
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*digestUser, error) {
+		x := &digestUser{}
+		if err := row.Scan(&x.id, &x.email, &x.frequency, &x.lastDigestAt); err != nil {
+			return nil, err
+		}
+		return x, nil
+	})
+}
+
+func (d *DigestRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+
+	if r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(d); err != nil {
+			return err
+		}
+	}
+
+	// Never trust a userID from the body
+	d.UserID = userID
+
+	// Keep only the address part of inputs like "Name <name@example.com>"
+	if d.Email != "" {
+		addr, err := mail.ParseAddress(d.Email)
+		if err != nil {
+			return err
+		}
+		d.Email = addr.Address
+	}
+
+	if d.Frequency != "" {
+		if err := validateDigestFrequency(d.Frequency); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// --------------------- Utility Layer -------------------------- //
+
+var errEmailChanged = errors.New("email was changed since the confirmation link was sent")
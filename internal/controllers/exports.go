@@ -0,0 +1,586 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	auth "github.com/app-clone-tod-auth"
+	mailer "github.com/app-clone-tod-mailer"
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ExportRequest struct {
+	UserID int `json:"userID,omitzero"`
+}
+
+type ExportResponse struct {
+	Message string      `json:"message,omitzero"`
+	Err     error       `json:"err,omitzero"`
+	Result  *DataExport `json:"result,omitzero"` // missing when the user never asked for an export
+}
+
+type DataExport struct {
+	ID          int       `json:"id,omitzero"`
+	Status      string    `json:"status,omitzero"`
+	CreatedAt   time.Time `json:"createdAt,omitzero"`
+	CompletedAt time.Time `json:"completedAt,omitzero"`
+	ExpiresAt   time.Time `json:"expiresAt,omitzero"`
+	DownloadURL string    `json:"downloadUrl,omitzero"` // signed link, only while the archive is ready
+}
+
+// One JSON file of an export archive. query takes the user id as $1 and returns a single json value.
+type exportSection struct {
+	file  string
+	query string
+}
+
+// Everything stored about a user, one file each. Whole rows are exported so new columns show up without
+// changes here; only the password hash is left out.
+var exportSections = []exportSection{
+	{"user.json", `SELECT to_jsonb(u) - 'password' FROM "User" u WHERE u."id" = $1`},
+	{"profile.json", `SELECT COALESCE((SELECT to_jsonb(x) FROM "Profile" x WHERE x."userId" = $1), 'null'::jsonb)`},
+	{"handle_history.json", exportRows(`"ProfileHandleHistory"`, `x."userId" = $1`)},
+	{"avatars.json", exportRows(`"Avatar"`, `x."userId" = $1`)},
+	{"posts.json", exportRows(`"Post"`, `x."authorId" = $1`)},
+	{"comments.json", exportRows(`"Comment"`, `x."authorId" = $1`)},
+	{"comment_revisions.json", exportRows(`"CommentRevision"`, `x."commentId" IN (SELECT "id" FROM "Comment" WHERE "authorId" = $1)`)},
+	{"post_reactions.json", exportRows(`"Reactions"`, `x."reactorId" = $1`)},
+	{"comment_reactions.json", exportRows(`"CommentReaction"`, `x."reactorId" = $1`)},
+	{"following.json", exportRows(`"UserNetwork"`, `x."followerId" = $1`)},
+	{"followers.json", exportRows(`"UserNetwork"`, `x."followingId" = $1`)},
+	{"follow_requests.json", exportRows(`"FollowRequest"`, `x."requesterId" = $1 OR x."targetId" = $1`)},
+	{"messages.json", exportRows(`"Messages"`, `x."authorId" = $1`)},
+	{"blocks.json", exportRows(`"UserBlock"`, `x."blockerId" = $1`)},
+	{"mutes.json", exportRows(`"UserMute"`, `x."muterId" = $1`)},
+	{"settings.json", `SELECT COALESCE((SELECT to_jsonb(x) FROM "UserSettings" x WHERE x."userId" = $1), 'null'::jsonb)`},
+	{"notification_preferences.json", exportRows(`"NotificationPreference"`, `x."userId" = $1`)},
+	{"sessions.json", exportRows(`"Session"`, sessionOwnedBy("x", "$1::integer"))},
+}
+
+// Handles the caller's data export: GET shows the latest one, POST asks for a new one.
+// Archives are built by RunDataExports, and the caller is notified when theirs is ready.
+func (c *Controller) Export(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		var (
+			response *ExportResponse
+			err      error
+		)
+
+		params := &ExportRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			response, err = params.GetExport(pool, r.Context())
+		case http.MethodPost:
+			response, err = params.PostExport(pool, r.Context())
+		default:
+			wr.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// Serves an export archive through the signed link of exportLink, which works without being logged in
+func (c *Controller) DownloadExport(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		var fileName string
+
+		query := r.URL.Query()
+
+		if err := auth.VerifySignedValues(query); err != nil || query.Get("action") != "export" {
+			fmt.Printf("error (auth): invalid export link\n")
+			wr.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		exportID, err := strconv.ParseInt(query.Get("export"), 10, 0)
+		if err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		userID, err := strconv.ParseInt(query.Get("user"), 10, 0)
+		if err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		err = pool.QueryRow(r.Context(), `
+			SELECT "fileName" FROM "DataExport"
+			WHERE "id" = $1 AND "userId" = $2 AND "status" = $3 AND "expiresAt" > $4`,
+			exportID, userID, customUtil.EXPORT_READY, time.Now()).Scan(&fileName)
+
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			fmt.Printf("error (params): export %d is gone\n", exportID)
+			wr.WriteHeader(http.StatusNotFound)
+			return
+		case err != nil:
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		dir, err := ExportDir()
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Replaces the json type set by AcceptJSON
+		wr.Header().Set("Content-Type", "application/zip")
+		wr.Header().Set("Content-Disposition", `attachment; filename="data-export.zip"`)
+		http.ServeFile(wr, r, filepath.Join(dir, fileName))
+	}
+}
+
+// Builds every requested export and removes expired archives each customUtil.EXPORT_INTERVAL until ctx is done
+func RunDataExports(ctx context.Context, pool *pgxpool.Pool, m mailer.Mailer) {
+	ticker := time.NewTicker(customUtil.EXPORT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if err := BuildPendingExports(ctx, pool, m, time.Now()); err != nil {
+			fmt.Printf("error (export): %s\n", err.Error())
+		}
+
+		if err := RemoveExpiredExports(ctx, pool, time.Now()); err != nil {
+			fmt.Printf("error (export): %s\n", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Builds requested exports one at a time until none is left. A failed build is marked as such, and the
+// user can ask again.
+func BuildPendingExports(ctx context.Context, pool *pgxpool.Pool, m mailer.Mailer, now time.Time) error {
+	for {
+		exportID, userID, err := claimExport(pool, ctx, now)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fileName, err := writeExport(pool, ctx, exportID, userID)
+		if err != nil {
+			fmt.Printf("error (export): export %d: %s\n", exportID, err.Error())
+
+			if _, err := pool.Exec(ctx, `UPDATE "DataExport" SET "status" = $2, "completedAt" = $3 WHERE "id" = $1`, exportID, customUtil.EXPORT_FAILED, time.Now()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		expiresAt := time.Now().Add(customUtil.EXPORT_LINK_TTL)
+
+		if err := finishExport(pool, ctx, exportID, userID, fileName, expiresAt); err != nil {
+			return err
+		}
+
+		if err := notifyExportReady(pool, ctx, m, exportID, userID, expiresAt); err != nil {
+			fmt.Printf("error (export): export %d: %s\n", exportID, err.Error())
+		}
+	}
+}
+
+// Deletes the archives and rows of exports whose link expired before now
+func RemoveExpiredExports(ctx context.Context, pool *pgxpool.Pool, now time.Time) error {
+	rows, _ := pool.Query(ctx, `DELETE FROM ONLY "DataExport" WHERE "expiresAt" <= $1 RETURNING COALESCE("fileName", '')`, now)
+
+	fileNames, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	return removeExportFiles(fileNames)
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (e *ExportRequest) GetExport(p *pgxpool.Pool, ctx context.Context) (*ExportResponse, error) {
+	response := &ExportResponse{}
+
+	if e.UserID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchLatestExport(p, ctx, e.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (e *ExportRequest) PostExport(p *pgxpool.Pool, ctx context.Context) (*ExportResponse, error) {
+	response := &ExportResponse{}
+
+	if e.UserID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.CreateExport(p, ctx, e.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+// Queues an export for userID. While one is already queued or being built, that one is returned instead.
+func (e *ExportResponse) CreateExport(p *pgxpool.Pool, ctx context.Context, userID int) error {
+	_, err := p.Exec(ctx, `
+		INSERT INTO "DataExport" ("userId", "status") VALUES ($1, $2)
+		ON CONFLICT ("userId") WHERE "status" IN ('pending', 'running') DO NOTHING`,
+		userID, customUtil.EXPORT_PENDING)
+
+	if err != nil {
+		return err
+	}
+
+	return e.FetchLatestExport(p, ctx, userID)
+}
+
+func (e *ExportResponse) FetchLatestExport(p *pgxpool.Pool, ctx context.Context, userID int) error {
+	var (
+		completedAt *time.Time
+		expiresAt   *time.Time
+		x           = &DataExport{}
+	)
+
+	err := p.QueryRow(ctx, `
+		SELECT "id", "status", "createdAt", "completedAt", "expiresAt" FROM "DataExport"
+		WHERE "userId" = $1
+		ORDER BY "createdAt" DESC, "id" DESC
+		LIMIT 1`, userID).Scan(&x.ID, &x.Status, &x.CreatedAt, &completedAt, &expiresAt)
+
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		x = nil
+	case err != nil:
+		return err
+	}
+
+	if x != nil && completedAt != nil {
+		x.CompletedAt = *completedAt
+	}
+
+	if x != nil && expiresAt != nil {
+		x.ExpiresAt = *expiresAt
+
+		if x.Status == customUtil.EXPORT_READY && time.Now().Before(x.ExpiresAt) {
+			if x.DownloadURL, err = exportLink(x.ID, userID, x.ExpiresAt); err != nil {
+				return err
+			}
+		}
+	}
+
+	e.Err = nil
+	e.Message = "Done!"
+	e.Result = x
+
+	return nil
+}
+
+// Marks the oldest queued export as running, along with exports whose build was lost (e.g. to a restart)
+func claimExport(p *pgxpool.Pool, ctx context.Context, now time.Time) (int, int, error) {
+	var exportID, userID int
+
+	err := p.QueryRow(ctx, `
+		UPDATE "DataExport" SET "status" = $1, "startedAt" = $2
+		WHERE "id" = (
+			SELECT "id" FROM "DataExport"
+			WHERE "status" = $3 OR ("status" = $1 AND "startedAt" < $4)
+			ORDER BY "createdAt", "id"
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING "id", "userId"`,
+		customUtil.EXPORT_RUNNING, now, customUtil.EXPORT_PENDING, now.Add(-customUtil.EXPORT_STALE_AFTER),
+	).Scan(&exportID, &userID)
+
+	return exportID, userID, err
+}
+
+// Marks the export ready. The archive is removed when the export is gone meanwhile (e.g. the account was purged).
+func finishExport(p *pgxpool.Pool, ctx context.Context, exportID, userID int, fileName string, expiresAt time.Time) error {
+	result, err := p.Exec(ctx, `
+		UPDATE "DataExport" SET "status" = $3, "fileName" = $4, "completedAt" = $5, "expiresAt" = $6
+		WHERE "id" = $1 AND "userId" = $2`,
+		exportID, userID, customUtil.EXPORT_READY, fileName, time.Now(), expiresAt)
+
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return removeExportFiles([]string{fileName})
+	}
+
+	return nil
+}
+
+// Writes the archive of userID into exportDir and returns its file name. Every section is read in one
+// repeatable read transaction, so the files agree with each other.
+func writeExport(p *pgxpool.Pool, ctx context.Context, exportID, userID int) (string, error) {
+	dir, err := ExportDir()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, "export-*.tmp")
+	if err != nil {
+		return "", err
+	}
+
+	// Removing fails harmlessly once the file has been renamed
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	archive := zip.NewWriter(tmp)
+
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return "", err
+	}
+
+	defer tx.Rollback(ctx)
+
+	for _, section := range exportSections {
+		var (
+			raw      []byte
+			indented bytes.Buffer
+		)
+
+		if err := tx.QueryRow(ctx, section.query, userID).Scan(&raw); err != nil {
+			return "", fmt.Errorf("%s: %w", section.file, err)
+		}
+
+		if err := json.Indent(&indented, raw, "", "  "); err != nil {
+			return "", err
+		}
+
+		w, err := archive.Create(section.file)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := indented.WriteTo(w); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+
+	if err := addExportMedia(archive, userID); err != nil {
+		return "", err
+	}
+
+	if err := archive.Close(); err != nil {
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	fileName := fmt.Sprintf("export-%d-%d.zip", userID, exportID)
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, fileName)); err != nil {
+		return "", err
+	}
+
+	return fileName, nil
+}
+
+// Adds the files userID uploaded (avatars) under media/ in the archive
+func addExportMedia(archive *zip.Writer, userID int) error {
+	mediaDir, err := MediaDir()
+	if err != nil {
+		return err
+	}
+
+	userDir := filepath.Join(mediaDir, "avatars", strconv.Itoa(userID))
+
+	err = filepath.WalkDir(userDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		name, err := filepath.Rel(mediaDir, path)
+		if err != nil {
+			return err
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		w, err := archive.Create(filepath.ToSlash(filepath.Join("media", name)))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(w, src)
+		return err
+	})
+
+	// Users who never uploaded anything have no directory
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Tells userID their archive can be downloaded, in the app and by email when they confirmed an address
+func notifyExportReady(p *pgxpool.Pool, ctx context.Context, m mailer.Mailer, exportID, userID int, expiresAt time.Time) error {
+	var email string
+
+	if err := Notify(p, ctx, &Notification{UserID: userID, Type: customUtil.NOTIFICATION_EXPORT_READY}); err != nil {
+		return err
+	}
+
+	if err := p.QueryRow(ctx, `SELECT CASE WHEN "emailVerifiedAt" IS NOT NULL THEN COALESCE("email", '') ELSE '' END FROM "User" WHERE "id" = $1`, userID).Scan(&email); err != nil {
+		return err
+	}
+
+	if email == "" {
+		return nil
+	}
+
+	link, err := exportLink(exportID, userID, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return m.Send(ctx, &mailer.Message{
+		To:      email,
+		Subject: "Your data export is ready",
+		Text: fmt.Sprintf("The copy of your data you asked for is ready. Download it before %s:\n\n%s\n",
+			expiresAt.UTC().Format("January 2, 2006 15:04 MST"), link),
+	})
+}
+
+func (e *ExportRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	e.UserID = userID
+
+	return nil
+}
+
+// --------------------- Utility Layer -------------------------- //
+
+// Json array of the rows of table matching where, aliased as x
+func exportRows(table, where string) string {
+	return fmt.Sprintf(`SELECT COALESCE(jsonb_agg(to_jsonb(x)), '[]'::jsonb) FROM %s x WHERE %s`, table, where)
+}
+
+// Returns the directory export archives are written to. It must not be served publicly like MediaDir.
+func ExportDir() (string, error) {
+	dir, ok := os.LookupEnv("EXPORT_DIR")
+	if !ok {
+		return "", errors.New("environment variable not found")
+	}
+
+	return dir, nil
+}
+
+func removeExportFiles(fileNames []string) error {
+	// Accounts that never exported anything have no files to look for
+	if len(fileNames) == 0 {
+		return nil
+	}
+
+	dir, err := ExportDir()
+	if err != nil {
+		return err
+	}
+
+	for _, fileName := range fileNames {
+		if fileName == "" {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, fileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Signed link that downloads an export without logging in, until expiresAt
+func exportLink(exportID, userID int, expiresAt time.Time) (string, error) {
+	serverURL, ok := os.LookupEnv("BASE_SERVER_URL")
+	if !ok {
+		return "", errors.New("environment variable not found")
+	}
+
+	query, err := auth.SignValues(url.Values{
+		"action":  {"export"},
+		"export":  {strconv.Itoa(exportID)},
+		"user":    {strconv.Itoa(userID)},
+		"expires": {strconv.FormatInt(expiresAt.Unix(), 10)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return serverURL + "/exports/?" + query, nil
+}
@@ -8,6 +8,8 @@ require github.com/app-clone-tod-utils v0.0.0-00010101000000-000000000000 // loc
 
 require github.com/app-clone-tod-auth v0.0.0-00010101000000-000000000000 // local package
 
+require github.com/app-clone-tod-mailer v0.0.0-00010101000000-000000000000 // local package
+
 require (
 	cloud.google.com/go/compute/metadata v0.3.0 // indirect
 	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
@@ -23,3 +25,5 @@ require (
 replace github.com/app-clone-tod-utils => ../utils/
 
 replace github.com/app-clone-tod-auth => ./auth/
+
+replace github.com/app-clone-tod-mailer => ../mailer/
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A resolved @handle inside a post or comment message
+type Mention struct {
+	UserID int    `json:"userId,omitzero"`
+	Handle string `json:"handle,omitzero"` // current handle of the user, which may differ from the text after a rename
+	Offset int    `json:"offset"`          // UTF-16 code units, matching JavaScript string indexing
+	Length int    `json:"length,omitzero"`
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+// Resolves the @handles of message and replaces the stored mentions of a post (commentID = 0)
+// or a comment (postID = 0). Unknown handles and users who blocked the author are left as plain text.
+//
+// Only users who were not mentioned before (i.e. on edits) get notified.
+func SetMentions(p *pgxpool.Pool, ctx context.Context, authorID, postID, commentID int, message string) ([]*Mention, error) {
+	var (
+		tokens   = customUtil.ParseMentions(message)
+		handles  = []string{}
+		mentions = []*Mention{}
+		previous = map[int]bool{}
+	)
+
+	// Handles are stored lowercased
+	for _, token := range tokens {
+		handles = append(handles, strings.ToLower(token.Handle))
+	}
+
+	users, err := resolveHandles(p, ctx, authorID, handles)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		if user, ok := users[strings.ToLower(token.Handle)]; ok {
+			mentions = append(mentions, &Mention{UserID: user.UserID, Handle: user.Handle, Offset: token.Offset, Length: token.Length})
+		}
+	}
+
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, _ := tx.Query(ctx, `DELETE FROM ONLY "Mention" WHERE "postId" = $1 OR "commentId" = $2 RETURNING "userId"`, postID, commentID)
+	previousIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range previousIDs {
+		previous[id] = true
+	}
+
+	for _, m := range mentions {
+		_, err := tx.Exec(
+			ctx,
+			`INSERT INTO "Mention" ("postId", "commentId", "userId", "authorId", "offset", "length") VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5, $6)`,
+			postID, commentID, m.UserID, authorID, m.Offset, m.Length,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, m := range mentions {
+		if previous[m.UserID] {
+			continue
+		}
+
+		// Prevents notifying a user twice for the same message
+		previous[m.UserID] = true
+
+		n := &Notification{UserID: m.UserID, ActorID: authorID, Type: customUtil.NOTIFICATION_MENTION, PostID: postID, CommentID: commentID}
+		if err := Notify(p, ctx, n); err != nil {
+			return nil, err
+		}
+	}
+
+	return mentions, nil
+}
+
+// Used by scanPost in posts.go and scanComment in comments.go
+func GetMentions(p *pgxpool.Pool, ctx context.Context, postID, commentID int) ([]*Mention, error) {
+	rows, _ := p.Query(
+		ctx,
+		`SELECT m."userId", COALESCE(pf."handle", ''), m."offset", m."length" FROM "Mention" m LEFT JOIN "Profile" pf ON pf."userId" = m."userId" WHERE m."postId" = $1 OR m."commentId" = $2 ORDER BY m."offset"`,
+		postID, commentID,
+	)
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Mention, error) {
+		x := &Mention{}
+		if err := row.Scan(&x.UserID, &x.Handle, &x.Offset, &x.Length); err != nil {
+			return nil, err
+		}
+		return x, nil
+	})
+}
+
+// Maps lowercased handles to users, leaving out users who blocked authorID. Profile handles are public,
+// unlike login usernames, and every account can have one.
+func resolveHandles(p *pgxpool.Pool, ctx context.Context, authorID int, handles []string) (map[string]*Mention, error) {
+	users := map[string]*Mention{}
+
+	if len(handles) == 0 {
+		return users, nil
+	}
+
+	rows, _ := p.Query(ctx, `
+		SELECT pf."userId", pf."handle" FROM "Profile" pf
+		WHERE pf."handle" = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM "UserBlock" b WHERE b."blockerId" = pf."userId" AND b."blockedId" = $2)`,
+		handles, authorID)
+
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Mention, error) {
+		x := &Mention{}
+		if err := row.Scan(&x.UserID, &x.Handle); err != nil {
+			return nil, err
+		}
+		return x, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range result {
+		users[user.Handle] = user
+	}
+
+	return users, nil
+}
@@ -7,28 +7,39 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	customUtil "github.com/app-clone-tod-utils"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type ProfileNetworkRequest struct {
-	MyFollowers bool `json:"myFollowers,omitzero"`
-	UserID      int  `json:"userID,omitzero"`
-	FollowerId  int  `json:"followerId,omitzero"`
+	MyFollowers bool      `json:"myFollowers,omitzero"`
+	UserID      int       `json:"userID,omitzero"`
+	FollowerId  int       `json:"followerId,omitzero"`
+	FollowingId int       `json:"followingId,omitzero"` // user the caller starts following
+	OwnerID     int       `json:"ownerId,omitzero"`     // user whose lists are read, the caller by default
+	Search      string    `json:"search,omitzero"`      // name or handle prefix
+	Cursor      string    `json:"cursor,omitzero"`      // nextCursor of the previous page
+	CursorAt    time.Time `json:"-"`                    // when the last user of the previous page was followed
+	CursorID    int       `json:"-"`                    // id of the last user of the previous page
+	Limit       int       `json:"limit,omitzero"`
 }
 
 type ProfileNetworkResponse struct {
-	Message string            `json:"message,omitzero"`
-	Err     error             `json:"err,omitzero"`
-	Result  []*ProfileNetwork `json:"result"`
+	Message    string            `json:"message,omitzero"`
+	Err        error             `json:"err,omitzero"`
+	Result     []*ProfileNetwork `json:"result"`
+	NextCursor string            `json:"nextCursor,omitzero"` // empty on the last page
 }
 
 type ProfileNetwork struct {
 	Name       Author    `json:"name,omitzero"`
 	UserID     int       `json:"userId,omitzero"`
 	AssignedAt time.Time `json:"assignedAt,omitzero"`
+	State      string    `json:"state,omitzero"` // following, or requested when the followed account is private
 }
 
 func (c *Controller) Network(pool *pgxpool.Pool) http.HandlerFunc {
@@ -77,25 +88,20 @@ func (c *Controller) Network(pool *pgxpool.Pool) http.HandlerFunc {
 
 // --------------------- Service Layer -------------------------- //
 
+// Lists the followers (myFollowers) or followees of the owner, the caller unless ?userId= is given
 func (pn *ProfileNetworkRequest) GetNetwork(p *pgxpool.Pool, ctx context.Context) (*ProfileNetworkResponse, error) {
-	var (
-		query    string
-		response = &ProfileNetworkResponse{}
-	)
+	response := &ProfileNetworkResponse{}
 
-	if pn.FollowerId == 0 || pn.UserID == 0 {
+	if pn.UserID == 0 {
 		return nil, errors.New("bad request body")
 	}
 
-	if pn.MyFollowers {
-		// fetch all that follow userId OR
-		query = `SELECT "followerId", "assignedAt" FROM "UserNetwork" WHERE "followingId" = $1`
-	} else {
-		// fetch all that userId follows
-		query = `SELECT "followingId", "assignedAt" FROM "UserNetwork" WHERE "followerId" = $1`
+	ownerID := pn.OwnerID
+	if ownerID == 0 {
+		ownerID = pn.UserID
 	}
 
-	if err := response.FetchNetwork(p, ctx, query, pn.UserID); err != nil {
+	if err := response.FetchNetworkPage(p, ctx, pn.UserID, ownerID, pn.MyFollowers, pn.Search, pn.CursorAt, pn.CursorID, pn.Limit); err != nil {
 		return nil, err
 	}
 
@@ -116,14 +122,15 @@ func (pn *ProfileNetworkRequest) DelNetwork(p *pgxpool.Pool, ctx context.Context
 	return response, nil
 }
 
+// The caller is always the follower
 func (pn *ProfileNetworkRequest) PostNetwork(p *pgxpool.Pool, ctx context.Context) (*ProfileNetworkResponse, error) {
-	if pn.FollowerId == 0 || pn.UserID == 0 {
+	if pn.FollowingId == 0 || pn.UserID == 0 {
 		return nil, errors.New("bad request body")
 	}
 
 	response := &ProfileNetworkResponse{}
 
-	if err := response.CreateNetwork(p, ctx, pn.FollowerId, pn.UserID); err != nil {
+	if err := response.CreateNetwork(p, ctx, pn.UserID, pn.FollowingId); err != nil {
 		return nil, err
 	}
 
@@ -150,57 +157,206 @@ func (pn *ProfileNetworkResponse) RemoveNetwork(p *pgxpool.Pool, ctx context.Con
 }
 
 func (pn *ProfileNetworkResponse) CreateNetwork(p *pgxpool.Pool, ctx context.Context, followerID, userID int) error {
-	x := &ProfileNetwork{
-		UserID: followerID,
+	var (
+		x   = &ProfileNetwork{UserID: userID}
+		err error
+	)
+
+	if x.State, x.AssignedAt, err = Follow(p, ctx, followerID, userID); err != nil {
+		return err
 	}
 
-	err := p.QueryRow(ctx, `INSERT INTO "UserNetwork" ("followerId", "followingId") VALUES ($1, $2) RETURNING "assignedAt"`, followerID, userID).Scan(&x.AssignedAt)
+	pn.Err = nil
+	pn.Message = "Done!"
+	pn.Result = []*ProfileNetwork{x}
+
+	return nil
+}
+
+// Runs a query returning the columns scanNetwork reads
+func (pn *ProfileNetworkResponse) FetchNetwork(p *pgxpool.Pool, ctx context.Context, query string, args ...any) error {
+	rows, _ := p.Query(ctx, query, args...)
+
+	result, err := pgx.CollectRows(rows, scanNetwork)
 	if err != nil {
 		return err
 	}
 
 	pn.Err = nil
 	pn.Message = "Done!"
-	pn.Result = []*ProfileNetwork{x}
+	pn.Result = result
 
 	return nil
 }
 
-func (pn *ProfileNetworkResponse) FetchNetwork(p *pgxpool.Pool, ctx context.Context, query string, userID int) error {
-	rows, _ := p.Query(ctx, query, userID)
+// Pages the followers (followers = true) or followees of ownerID as seen by viewerID, latest first, optionally
+// narrowed to names or handles starting with search. The cursor is when the last user sent was followed along
+// with their id, so it keeps working after that user unfollows.
+// Nothing is listed when the owner is private and not followed by the viewer, or when either blocked the other.
+func (pn *ProfileNetworkResponse) FetchNetworkPage(p *pgxpool.Pool, ctx context.Context, viewerID, ownerID int, followers bool, search string, cursorAt time.Time, cursorID, limit int) error {
+	var nextCursor string
+
+	owner, other := `"followerId"`, `"followingId"`
+	if followers {
+		owner, other = `"followingId"`, `"followerId"`
+	}
 
-	result, err := pgx.CollectRows(rows, scanNetwork(p, ctx))
+	rows, _ := p.Query(ctx, `
+		SELECT n.`+other+`, n."assignedAt", COALESCE(pf."firstName", ''), COALESCE(pf."lastName", ''), COALESCE(pf."profileUrl", '')
+		FROM "UserNetwork" n
+		LEFT JOIN "Profile" pf ON pf."userId" = n.`+other+`
+		WHERE n.`+owner+` = $1
+		AND `+profileVisible("$1", "$2")+` AND `+notBlocked("$1", "$2")+` AND `+notBlocked("n."+other, "$2")+`
+		AND ($3 = '' OR pf."handle" LIKE lower($3) || '%' OR pf."firstName" ILIKE $3 || '%' OR pf."lastName" ILIKE $3 || '%'
+			OR (pf."firstName" || ' ' || pf."lastName") ILIKE $3 || '%')
+		AND ($5 = 0 OR (n."assignedAt", n.`+other+`) < ($4, $5))
+		ORDER BY n."assignedAt" DESC, n.`+other+` DESC
+		LIMIT $6`, ownerID, viewerID, escapeLike(search), cursorAt, cursorID, limit+1)
+
+	result, err := pgx.CollectRows(rows, scanNetwork)
 	if err != nil {
 		return err
 	}
 
+	// The extra row only tells whether another page exists
+	if len(result) > limit {
+		result = result[:limit]
+		nextCursor = encodeNetworkCursor(result[limit-1].AssignedAt, result[limit-1].UserID)
+	}
+
 	pn.Err = nil
 	pn.Message = "Done!"
 	pn.Result = result
+	pn.NextCursor = nextCursor
 
 	return nil
 }
 
-func scanNetwork(p *pgxpool.Pool, ctx context.Context) (fn pgx.RowToFunc[*ProfileNetwork]) {
-	return func(row pgx.CollectableRow) (*ProfileNetwork, error) {
-		author := &Author{}
-		x := &ProfileNetwork{}
-		var err error
+// Makes followerID follow userID right away when userID is public, or sends userID a follow request when private.
+// Returns the resulting follow state and when it began. Following someone already followed changes nothing.
+func Follow(p *pgxpool.Pool, ctx context.Context, followerID, userID int) (string, time.Time, error) {
+	var (
+		isPrivate bool
+		since     time.Time
+	)
+
+	if followerID == userID {
+		return "", since, errors.New("users cannot follow themselves")
+	}
+
+	if blocked, err := IsBlocked(p, ctx, followerID, userID); err != nil {
+		return "", since, err
+	} else if blocked {
+		return "", since, errors.New("user is blocked")
+	}
+
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return "", since, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Users without a profile yet are public
+	err = tx.QueryRow(ctx, `SELECT COALESCE(pf."isPrivate", false) FROM "User" u LEFT JOIN "Profile" pf ON pf."userId" = u."id" WHERE u."id" = $1`, userID).Scan(&isPrivate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", since, errors.New("user not found")
+	} else if err != nil {
+		return "", since, err
+	}
+
+	err = tx.QueryRow(ctx, `SELECT "assignedAt" FROM "UserNetwork" WHERE "followerId" = $1 AND "followingId" = $2`, followerID, userID).Scan(&since)
+	if err == nil {
+		return customUtil.FOLLOW_STATE_FOLLOWING, since, nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return "", since, err
+	}
+
+	notification := &Notification{UserID: userID, ActorID: followerID, Type: customUtil.NOTIFICATION_FOLLOW_REQUEST}
+	state := customUtil.FOLLOW_STATE_REQUESTED
 
-		if err = row.Scan(&x.UserID, &x.AssignedAt); err != nil {
-			return nil, err
+	if isPrivate {
+		err = tx.QueryRow(ctx, `SELECT "createdAt" FROM "FollowRequest" WHERE "requesterId" = $1 AND "targetId" = $2`, followerID, userID).Scan(&since)
+		if err == nil {
+			return state, since, nil
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return "", since, err
 		}
 
-		// Get details (AKA Author details)
-		if author, err = FetchAuthor(p, ctx, x.UserID); err != nil {
-			return nil, err
+		err = tx.QueryRow(ctx, `INSERT INTO "FollowRequest" ("requesterId", "targetId") VALUES ($1, $2) RETURNING "createdAt"`, followerID, userID).Scan(&since)
+	} else {
+		notification.Type = customUtil.NOTIFICATION_FOLLOW
+		state = customUtil.FOLLOW_STATE_FOLLOWING
+
+		// A request left over from when the account was private is settled by following
+		if _, err := tx.Exec(ctx, `DELETE FROM ONLY "FollowRequest" WHERE "requesterId" = $1 AND "targetId" = $2`, followerID, userID); err != nil {
+			return "", since, err
 		}
 
-		x.Name.FirstName = author.FirstName
-		x.Name.LastName = author.LastName
+		err = tx.QueryRow(ctx, `INSERT INTO "UserNetwork" ("followerId", "followingId") VALUES ($1, $2) RETURNING "assignedAt"`, followerID, userID).Scan(&since)
+	}
+
+	if err != nil {
+		return "", since, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", since, err
+	}
 
-		return x, nil
+	if err := Notify(p, ctx, notification); err != nil {
+		return "", since, err
 	}
+
+	return state, since, nil
+}
+
+// Posts of private accounts are only listed to their author and approved followers, and posts are never
+// listed between users where one blocked the other.
+// post is the alias of the "Post" table and viewer the placeholder of the viewer's id.
+func postVisible(post, viewer string) string {
+	return profileVisible(post+`."authorId"`, viewer) + ` AND ` + notBlocked(post+`."authorId"`, viewer)
+}
+
+// SQL condition that the followers and posts of userID are open to viewer: userID is the viewer, is public,
+// or is followed by the viewer. Both arguments are SQL expressions of user ids, which may refer to the
+// common "pf" and "un" aliases of the caller's query, so the subqueries use their own.
+func profileVisible(userID, viewer string) string {
+	return fmt.Sprintf(`(%[1]s = %[2]s OR NOT EXISTS (
+		SELECT 1 FROM "Profile" vpf WHERE vpf."userId" = %[1]s AND vpf."isPrivate"
+	) OR EXISTS (
+		SELECT 1 FROM "UserNetwork" vun WHERE vun."followerId" = %[2]s AND vun."followingId" = %[1]s
+	))`, userID, viewer)
+}
+
+// How viewerID relates to userID: following, requested, self or none
+func GetFollowState(p *pgxpool.Pool, ctx context.Context, viewerID, userID int) (string, error) {
+	var state string
+
+	if viewerID == userID {
+		return customUtil.FOLLOW_STATE_SELF, nil
+	}
+
+	err := p.QueryRow(ctx, `
+		SELECT CASE
+			WHEN EXISTS (SELECT 1 FROM "UserNetwork" WHERE "followerId" = $1 AND "followingId" = $2) THEN $3
+			WHEN EXISTS (SELECT 1 FROM "FollowRequest" WHERE "requesterId" = $1 AND "targetId" = $2) THEN $4
+			ELSE $5
+		END`,
+		viewerID, userID, customUtil.FOLLOW_STATE_FOLLOWING, customUtil.FOLLOW_STATE_REQUESTED, customUtil.FOLLOW_STATE_NONE,
+	).Scan(&state)
+
+	return state, err
+}
+
+// Reads the other user of a "UserNetwork" row along with their profile, so lists need no query per row
+func scanNetwork(row pgx.CollectableRow) (*ProfileNetwork, error) {
+	x := &ProfileNetwork{}
+
+	if err := row.Scan(&x.UserID, &x.AssignedAt, &x.Name.FirstName, &x.Name.LastName, &x.Name.AvatarURL); err != nil {
+		return nil, err
+	}
+
+	return x, nil
 }
 
 func (pr *ProfileNetworkRequest) Parse(r *http.Request) error {
@@ -228,5 +384,67 @@ func (pr *ProfileNetworkRequest) Parse(r *http.Request) error {
 		}
 	}
 
+	if followingID := r.FormValue("followingId"); followingID != "" {
+		if num, err := strconv.ParseInt(followingID, 10, 0); err != nil {
+			return err
+		} else {
+			pr.FollowingId = int(num)
+		}
+	}
+
+	if ownerID := r.FormValue("userId"); ownerID != "" {
+		if num, err := strconv.ParseInt(ownerID, 10, 0); err != nil {
+			return err
+		} else {
+			pr.OwnerID = int(num)
+		}
+	}
+
+	if pr.Cursor = r.FormValue("cursor"); pr.Cursor != "" {
+		at, id, err := decodeNetworkCursor(pr.Cursor)
+		if err != nil {
+			return err
+		}
+		pr.CursorAt, pr.CursorID = at, id
+	}
+
+	// Handles are searched without their "@"
+	pr.Search = strings.TrimPrefix(strings.TrimSpace(r.FormValue("q")), "@")
+
+	if limit, err := parseLimit(r); err != nil {
+		return err
+	} else {
+		pr.Limit = limit
+	}
+
 	return nil
 }
+
+// --------------------- Utility Layer -------------------------- //
+
+// Encodes the position of a network row as "<assignedAt in unix microseconds>_<user id>"
+func encodeNetworkCursor(assignedAt time.Time, userID int) string {
+	return strconv.FormatInt(assignedAt.UnixMicro(), 10) + "_" + strconv.Itoa(userID)
+}
+
+func decodeNetworkCursor(cursor string) (time.Time, int, error) {
+	at, id, ok := strings.Cut(cursor, "_")
+	if !ok {
+		return time.Time{}, 0, errors.New("malformed cursor")
+	}
+
+	micros, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	userID, err := strconv.ParseInt(id, 10, 0)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if userID <= 0 {
+		return time.Time{}, 0, errors.New("malformed cursor")
+	}
+
+	return time.UnixMicro(micros).UTC(), int(userID), nil
+}
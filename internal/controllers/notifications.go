@@ -0,0 +1,455 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type NotificationRequest struct {
+	UserID      int             `json:"userID,omitzero"`
+	Cursor      int             `json:"cursor,omitzero"` // "cursor" of the last group of the previous page
+	Limit       int             `json:"limit,omitzero"`
+	UnreadOnly  bool            `json:"unreadOnly,omitzero"`
+	IDs         []int           `json:"ids,omitzero"` // notifications to mark read
+	All         bool            `json:"all,omitzero"` // marks every notification read
+	Preferences map[string]bool `json:"preferences,omitzero"`
+}
+
+type NotificationResponse struct {
+	Message     string               `json:"message,omitzero"`
+	Err         error                `json:"err,omitzero"`
+	Result      []*NotificationGroup `json:"result,omitzero"`
+	Unread      int                  `json:"unread"`
+	Preferences map[string]bool      `json:"preferences,omitzero"`
+}
+
+type Notification struct {
+	ID        int        `json:"id,omitzero"`
+	UserID    int        `json:"userId,omitzero"` // recipient
+	ActorID   int        `json:"actorId,omitzero"`
+	Type      string     `json:"type,omitzero"`
+	PostID    int        `json:"postId,omitzero"`
+	CommentID int        `json:"commentId,omitzero"`
+	ReadAt    *time.Time `json:"readAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt,omitzero"`
+}
+
+// One or more notifications of the same type about the same target (e.g. "5 people liked your post")
+type NotificationGroup struct {
+	Type      string    `json:"type,omitzero"`
+	PostID    int       `json:"postId,omitzero"`
+	CommentID int       `json:"commentId,omitzero"`
+	IDs       []int     `json:"ids,omitzero"`
+	Actors    []*Author `json:"actors,omitzero"` // most recent first, at most customUtil.NOTIFICATION_GROUP_ACTORS
+	Count     int       `json:"count,omitzero"`  // distinct actors
+	Summary   string    `json:"summary,omitzero"`
+	Read      bool      `json:"read"`
+	LatestAt  time.Time `json:"latestAt,omitzero"`
+	Cursor    int       `json:"cursor,omitzero"`
+}
+
+// Notifications already stored stop showing once the recipient blocks or mutes the actor (or is blocked by them)
+var notificationShown = `("actorId" IS NULL OR (` + notBlocked(`"userId"`, `"actorId"`) + ` AND ` + notMuted(`"userId"`, `"actorId"`) + `))`
+
+// Completes "<actors> ..." in a group summary
+var notificationVerbs = map[string]string{
+	customUtil.NOTIFICATION_MENTION:          "mentioned you",
+	customUtil.NOTIFICATION_FOLLOW:           "started following you",
+	customUtil.NOTIFICATION_FOLLOW_REQUEST:   "requested to follow you",
+	customUtil.NOTIFICATION_REACTION:         "reacted to your post",
+	customUtil.NOTIFICATION_COMMENT_REACTION: "reacted to your comment",
+	customUtil.NOTIFICATION_COMMENT:          "commented on your post",
+	customUtil.NOTIFICATION_REPLY:            "replied to your comment",
+	customUtil.NOTIFICATION_FOLLOW_ACCEPT:    "accepted your follow request",
+	customUtil.NOTIFICATION_FOLLOW_DECLINE:   "declined your follow request",
+}
+
+// Whole summaries of notifications the app sends itself, which have no actors
+var systemNotificationSummaries = map[string]string{
+	customUtil.NOTIFICATION_EXPORT_READY: "Your data export is ready to download",
+}
+
+// Handles listing (GET) and marking notifications read (PUT)
+func (c *Controller) Notification(pool *pgxpool.Pool) http.HandlerFunc {
+	return notificationHandler(pool, map[string]func(*NotificationRequest, *pgxpool.Pool, context.Context) (*NotificationResponse, error){
+		http.MethodGet: (*NotificationRequest).GetNotifications,
+		http.MethodPut: (*NotificationRequest).PutNotificationsRead,
+	})
+}
+
+// Handles the unread badge count
+func (c *Controller) NotificationCount(pool *pgxpool.Pool) http.HandlerFunc {
+	return notificationHandler(pool, map[string]func(*NotificationRequest, *pgxpool.Pool, context.Context) (*NotificationResponse, error){
+		http.MethodGet: (*NotificationRequest).GetUnreadCount,
+	})
+}
+
+// Handles per-type notification preferences
+func (c *Controller) NotificationPreference(pool *pgxpool.Pool) http.HandlerFunc {
+	return notificationHandler(pool, map[string]func(*NotificationRequest, *pgxpool.Pool, context.Context) (*NotificationResponse, error){
+		http.MethodGet: (*NotificationRequest).GetPreferences,
+		http.MethodPut: (*NotificationRequest).PutPreferences,
+	})
+}
+
+func notificationHandler(pool *pgxpool.Pool, methods map[string]func(*NotificationRequest, *pgxpool.Pool, context.Context) (*NotificationResponse, error)) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		fn, ok := methods[r.Method]
+		if !ok {
+			wr.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := &NotificationRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := fn(params, pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (n *NotificationRequest) GetNotifications(p *pgxpool.Pool, ctx context.Context) (*NotificationResponse, error) {
+	response := &NotificationResponse{}
+
+	if err := response.FetchNotifications(p, ctx, n.UserID, n.Cursor, n.Limit, n.UnreadOnly); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (n *NotificationRequest) PutNotificationsRead(p *pgxpool.Pool, ctx context.Context) (*NotificationResponse, error) {
+	response := &NotificationResponse{}
+
+	if !n.All && len(n.IDs) == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.UpdateNotificationsRead(p, ctx, n.UserID, n.IDs, n.All); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (n *NotificationRequest) GetUnreadCount(p *pgxpool.Pool, ctx context.Context) (*NotificationResponse, error) {
+	response := &NotificationResponse{}
+
+	if err := response.FetchUnreadCount(p, ctx, n.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (n *NotificationRequest) GetPreferences(p *pgxpool.Pool, ctx context.Context) (*NotificationResponse, error) {
+	response := &NotificationResponse{}
+
+	if err := response.FetchPreferences(p, ctx, n.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (n *NotificationRequest) PutPreferences(p *pgxpool.Pool, ctx context.Context) (*NotificationResponse, error) {
+	response := &NotificationResponse{}
+
+	if len(n.Preferences) == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	for notificationType := range n.Preferences {
+		if !slices.Contains(customUtil.NotificationTypes, notificationType) {
+			return nil, fmt.Errorf("unknown notification type %q", notificationType)
+		}
+	}
+
+	if err := response.UpdatePreferences(p, ctx, n.UserID, n.Preferences); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+// Stores a notification for n.UserID unless they opted out of n.Type or muted n.ActorID.
+// Users are never notified of their own actions, nor of the actions of users in a block with them.
+//
+// Used by write paths that other users should hear about (follows, requests, reactions, comments, mentions)
+func Notify(p *pgxpool.Pool, ctx context.Context, n *Notification) error {
+	if n.UserID == 0 || n.UserID == n.ActorID {
+		return nil
+	}
+
+	err := p.QueryRow(ctx, `
+		INSERT INTO "Notification" ("userId", "actorId", "type", "postId", "commentId")
+		SELECT $1::integer, NULLIF($2::integer, 0), $3::text, NULLIF($4::integer, 0), NULLIF($5::integer, 0)
+		WHERE NOT EXISTS (SELECT 1 FROM "NotificationPreference" WHERE "userId" = $1 AND "type" = $3 AND "enabled" = false)
+		AND `+notBlocked("$1", "$2")+` AND `+notMuted("$1", "$2")+`
+		RETURNING "id", "createdAt"`,
+		n.UserID, n.ActorID, n.Type, n.PostID, n.CommentID,
+	).Scan(&n.ID, &n.CreatedAt)
+
+	// No row means the recipient disabled this type or does not hear from the actor
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+
+	return err
+}
+
+// Groups notifications of a grouped type by target and read state, so new activity on an
+// already read target starts a fresh group. Other types are one group per notification.
+func (n *NotificationResponse) FetchNotifications(p *pgxpool.Pool, ctx context.Context, userID, cursor, limit int, unreadOnly bool) error {
+	rows, _ := p.Query(ctx, `
+		SELECT "type", COALESCE("postId", 0), COALESCE("commentId", 0),
+			array_agg("id" ORDER BY "id" DESC),
+			array_agg(COALESCE("actorId", 0) ORDER BY "id" DESC),
+			COUNT(DISTINCT "actorId"),
+			MAX("createdAt"),
+			bool_and("readAt" IS NOT NULL),
+			MAX("id")
+		FROM "Notification"
+		WHERE "userId" = $1 AND (NOT $5 OR "readAt" IS NULL) AND `+notificationShown+`
+		GROUP BY "type", "postId", "commentId", "readAt" IS NULL, CASE WHEN "type" = ANY($2) THEN 0 ELSE "id" END
+		HAVING $3 = 0 OR MAX("id") < $3
+		ORDER BY MAX("id") DESC
+		LIMIT $4`,
+		userID, customUtil.GroupedNotificationTypes, cursor, limit, unreadOnly)
+
+	result, err := pgx.CollectRows(rows, scanNotificationGroup(p, ctx))
+	if err != nil {
+		return err
+	}
+
+	if err := n.FetchUnreadCount(p, ctx, userID); err != nil {
+		return err
+	}
+
+	n.Err = nil
+	n.Message = "Done!"
+	n.Result = result
+
+	return nil
+}
+
+func (n *NotificationResponse) UpdateNotificationsRead(p *pgxpool.Pool, ctx context.Context, userID int, ids []int, all bool) error {
+	_, err := p.Exec(ctx, `UPDATE "Notification" SET "readAt" = $1 WHERE "userId" = $2 AND "readAt" IS NULL AND ($3 OR "id" = ANY($4))`, time.Now(), userID, all, ids)
+	if err != nil {
+		return err
+	}
+
+	if err := n.FetchUnreadCount(p, ctx, userID); err != nil {
+		return err
+	}
+
+	n.Err = nil
+	n.Message = "Done!"
+
+	return nil
+}
+
+// Counts unread groups rather than rows, matching what FetchNotifications lists
+func (n *NotificationResponse) FetchUnreadCount(p *pgxpool.Pool, ctx context.Context, userID int) error {
+	err := p.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT ("type", "postId", "commentId", CASE WHEN "type" = ANY($2) THEN 0 ELSE "id" END))
+		FROM "Notification"
+		WHERE "userId" = $1 AND "readAt" IS NULL AND `+notificationShown,
+		userID, customUtil.GroupedNotificationTypes,
+	).Scan(&n.Unread)
+
+	if err != nil {
+		return err
+	}
+
+	n.Err = nil
+	n.Message = "Done!"
+
+	return nil
+}
+
+// Returns every notification type, enabled unless the user opted out
+func (n *NotificationResponse) FetchPreferences(p *pgxpool.Pool, ctx context.Context, userID int) error {
+	preferences := map[string]bool{}
+	for _, notificationType := range customUtil.NotificationTypes {
+		preferences[notificationType] = true
+	}
+
+	rows, _ := p.Query(ctx, `SELECT "type", "enabled" FROM "NotificationPreference" WHERE "userId" = $1`, userID)
+
+	var (
+		notificationType string
+		enabled          bool
+	)
+
+	_, err := pgx.ForEachRow(rows, []any{&notificationType, &enabled}, func() error {
+		preferences[notificationType] = enabled
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	n.Err = nil
+	n.Message = "Done!"
+	n.Preferences = preferences
+
+	return nil
+}
+
+func (n *NotificationResponse) UpdatePreferences(p *pgxpool.Pool, ctx context.Context, userID int, preferences map[string]bool) error {
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for notificationType, enabled := range preferences {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO "NotificationPreference" ("userId", "type", "enabled", "updatedAt") VALUES ($1, $2, $3, $4)
+			ON CONFLICT ("userId", "type") DO UPDATE SET "enabled" = EXCLUDED."enabled", "updatedAt" = EXCLUDED."updatedAt"`,
+			userID, notificationType, enabled, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return n.FetchPreferences(p, ctx, userID)
+}
+
+func scanNotificationGroup(p *pgxpool.Pool, ctx context.Context) pgx.RowToFunc[*NotificationGroup] {
+	return func(row pgx.CollectableRow) (*NotificationGroup, error) {
+		var (
+			x        = &NotificationGroup{}
+			actorIDs []int
+			seen     = map[int]bool{}
+		)
+
+		if err := row.Scan(&x.Type, &x.PostID, &x.CommentID, &x.IDs, &actorIDs, &x.Count, &x.LatestAt, &x.Read, &x.Cursor); err != nil {
+			return nil, err
+		}
+
+		// actorIDs is ordered newest first and may repeat the same actor
+		for _, actorID := range actorIDs {
+			if actorID == 0 || seen[actorID] || len(x.Actors) == customUtil.NOTIFICATION_GROUP_ACTORS {
+				continue
+			}
+			seen[actorID] = true
+
+			author, err := FetchAuthor(p, ctx, actorID)
+			if err != nil {
+				return nil, err
+			}
+			x.Actors = append(x.Actors, author)
+		}
+
+		x.Summary = summarizeNotification(x)
+
+		return x, nil
+	}
+}
+
+// e.g. "Ada Lovelace and 4 others reacted to your post"
+func summarizeNotification(x *NotificationGroup) string {
+	if summary, ok := systemNotificationSummaries[x.Type]; ok {
+		return summary
+	}
+
+	names := []string{}
+	for _, actor := range x.Actors {
+		names = append(names, strings.TrimSpace(actor.FirstName+" "+actor.LastName))
+	}
+
+	var who string
+	switch {
+	case len(names) == 0:
+		who = "Someone"
+	case x.Count <= 1:
+		who = names[0]
+	case x.Count == 2 && len(names) == 2:
+		who = names[0] + " and " + names[1]
+	case x.Count == 2:
+		who = names[0] + " and 1 other"
+	default:
+		who = fmt.Sprintf("%s and %d others", names[0], x.Count-1)
+	}
+
+	return who + " " + notificationVerbs[x.Type]
+}
+
+func (n *NotificationRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+
+	if r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(n); err != nil {
+			return err
+		}
+	}
+
+	// Never trust a userID from the body
+	n.UserID = userID
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		num, err := strconv.ParseInt(cursor, 10, 0)
+		if err != nil {
+			return err
+		}
+		n.Cursor = int(num)
+	}
+
+	if unreadOnly := r.URL.Query().Get("unread"); unreadOnly != "" {
+		bl, err := strconv.ParseBool(unreadOnly)
+		if err != nil {
+			return err
+		}
+		n.UnreadOnly = bl
+	}
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		return err
+	}
+	n.Limit = limit
+
+	return nil
+}
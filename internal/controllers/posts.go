@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	customUtil "github.com/app-clone-tod-utils"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -33,22 +34,25 @@ type PostResponse struct {
 }
 
 type Post struct {
-	Id         int       `json:"id,omitzero"`
-	Title      string    `json:"title,omitzero"`
-	Message    string    `json:"message,omitzero"`
-	CreatedAt  time.Time `json:"createdAt,omitzero"`
-	UpdatedAt  time.Time `json:"updatedAt,omitzero"`
-	CategoryID int       `json:"categoryID,omitzero"`
-	IsDeleted  bool      `json:"isDeleted,omitzero"`
-	Published  bool      `json:"published,omitzero"` // is false automatically when missing from response result
-	Author     Author    `json:"author,omitzero"`
+	Id         int        `json:"id,omitzero"`
+	Title      string     `json:"title,omitzero"`
+	Message    string     `json:"message,omitzero"`
+	CreatedAt  time.Time  `json:"createdAt,omitzero"`
+	UpdatedAt  time.Time  `json:"updatedAt,omitzero"`
+	CategoryID int        `json:"categoryID,omitzero"`
+	IsDeleted  bool       `json:"isDeleted,omitzero"`
+	Published  bool       `json:"published,omitzero"` // is false automatically when missing from response result
+	Author     Author     `json:"author,omitzero"`
+	Tags       []string   `json:"tags,omitzero"`
+	Mentions   []*Mention `json:"mentions,omitzero"`
 
 	Count struct {
 		Reactions int `json:"reactions,omitempty"`
 		Comments  int `json:"comments,omitempty"`
 	} `json:"_count,omitzero"`
 
-	Reactions []*Reaction `json:"reactions,omitzero"`
+	Reactions  []*ReactionCount `json:"reactions,omitzero"`
+	MyReaction int              `json:"myReaction,omitzero"` // reactID of the caller's reaction
 }
 
 func (c *Controller) BasePostRoute(pool *pgxpool.Pool) http.HandlerFunc {
@@ -296,6 +300,21 @@ func (pr *PostRequest) PutPost(p *pgxpool.Pool, ctx context.Context) (*PostRespo
 		return nil, err
 	}
 
+	// Re-tag only when the message itself was edited
+	if strings.TrimSpace(pr.Message) != "" {
+		tags := customUtil.ParseHashtags(pr.Message)
+		if err := SetPostTags(p, ctx, pr.PostID, tags); err != nil {
+			return nil, err
+		}
+		response.Result[0].Tags = tags
+
+		mentions, err := SetMentions(p, ctx, pr.AuthorID, pr.PostID, 0, pr.Message)
+		if err != nil {
+			return nil, err
+		}
+		response.Result[0].Mentions = mentions
+	}
+
 	return response, nil
 }
 
@@ -313,7 +332,8 @@ func (pr *PostRequest) PostPost(p *pgxpool.Pool, ctx context.Context) (*PostResp
 		response  = &PostResponse{}
 	)
 
-	message := strings.ReplaceAll(pr.Message, " ", "")
+	// Stored as-is (minus surrounding whitespace) so mention offsets line up with the message
+	message := strings.TrimSpace(pr.Message)
 
 	// empty message should be left nil in db
 	if message != "" {
@@ -330,12 +350,41 @@ func (pr *PostRequest) PostPost(p *pgxpool.Pool, ctx context.Context) (*PostResp
 		pos++
 	}
 
+	// Posts sent without a published flag follow the author's default post visibility setting
+	published := pr.Published
+	if published == nil {
+		settings := &SettingsResponse{}
+		if err := settings.FetchSettings(p, ctx, pr.AuthorID); err != nil {
+			return nil, err
+		}
+
+		published = new(bool)
+		*published = settings.Result.DefaultPostVisibility == customUtil.POST_VISIBILITY_PUBLISHED
+	}
+
+	table = append(table, `"published"`)
+	argPos = append(argPos, fmt.Sprintf("$%d", pos))
+	sqlArgs = append(sqlArgs, *published)
+
 	query := fmt.Sprintf(`INSERT INTO "Post" (%s) VALUES (%s) RETURNING "id", "authorId", "createdAt"`, strings.Join(table, ", "), strings.Join(argPos, ", "))
 
 	if err := response.CreatePost(p, ctx, query, sqlArgs...); err != nil {
 		return nil, err
 	}
 
+	for _, post := range response.Result {
+		post.Tags = customUtil.ParseHashtags(message)
+		if err := SetPostTags(p, ctx, post.Id, post.Tags); err != nil {
+			return nil, err
+		}
+
+		mentions, err := SetMentions(p, ctx, pr.AuthorID, post.Id, 0, message)
+		if err != nil {
+			return nil, err
+		}
+		post.Mentions = mentions
+	}
+
 	return response, nil
 }
 
@@ -370,8 +419,7 @@ func (pr *PostResponse) RemovePost(p *pgxpool.Pool, ctx context.Context, postID
 		return err
 	}
 
-	x.Author.FirstName = author.FirstName
-	x.Author.LastName = author.LastName
+	x.Author = *author
 
 	pr.Err = nil
 	pr.Message = "Done!"
@@ -382,7 +430,9 @@ func (pr *PostResponse) RemovePost(p *pgxpool.Pool, ctx context.Context, postID
 
 func (pr *PostResponse) FetchPost(p *pgxpool.Pool, ctx context.Context, postID int) error {
 
-	rows, _ := p.Query(context.Background(), `SELECT * FROM "Post" WHERE id = $1`, postID)
+	viewerID, _ := UserFromContext(ctx)
+
+	rows, _ := p.Query(context.Background(), `SELECT * FROM "Post" WHERE id = $1 AND `+postVisible(`"Post"`, "$2"), postID, viewerID)
 
 	result, err := pgx.CollectRows(rows, scanPost(p, ctx))
 	if err != nil {
@@ -442,8 +492,7 @@ func (pr *PostResponse) CreatePost(p *pgxpool.Pool, ctx context.Context, query s
 			return nil, err
 		}
 
-		x.Author.FirstName = author.FirstName
-		x.Author.LastName = author.LastName
+		x.Author = *author
 		x.CreatedAt = createdAt
 
 		return x, nil
@@ -460,7 +509,9 @@ func (pr *PostResponse) CreatePost(p *pgxpool.Pool, ctx context.Context, query s
 }
 
 func (pr *PostResponse) CreatePosts(p *pgxpool.Pool, ctx context.Context, categoryID int, published bool) error {
-	rows, _ := p.Query(context.Background(), `SELECT * FROM  "Post" WHERE "categoryId" = $1 AND published = $2`, categoryID, published)
+	viewerID, _ := UserFromContext(ctx)
+
+	rows, _ := p.Query(context.Background(), `SELECT * FROM  "Post" WHERE "categoryId" = $1 AND published = $2 AND `+postVisible(`"Post"`, "$3")+` AND `+notMuted("$3", `"Post"."authorId"`), categoryID, published, viewerID)
 
 	result, err := pgx.CollectRows(rows, scanPost(p, ctx))
 	if err != nil {
@@ -474,7 +525,9 @@ func (pr *PostResponse) CreatePosts(p *pgxpool.Pool, ctx context.Context, catego
 }
 
 func (pr *PostResponse) FetchPostsBetween(p *pgxpool.Pool, ctx context.Context, categoryID int, published bool, start, end time.Time) error {
-	rows, _ := p.Query(context.Background(), `SELECT * FROM  "Post" WHERE "categoryId" = $1 AND published = $2 AND "updatedAt" BETWEEN $3 AND $4 ORDER BY id`, categoryID, published, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	viewerID, _ := UserFromContext(ctx)
+
+	rows, _ := p.Query(context.Background(), `SELECT * FROM  "Post" WHERE "categoryId" = $1 AND published = $2 AND "updatedAt" BETWEEN $3 AND $4 AND `+postVisible(`"Post"`, "$5")+` AND `+notMuted("$5", `"Post"."authorId"`)+` ORDER BY id`, categoryID, published, start.Format(time.RFC3339), end.Format(time.RFC3339), viewerID)
 
 	result, err := pgx.CollectRows(rows, scanPost(p, ctx))
 	if err != nil {
@@ -489,7 +542,9 @@ func (pr *PostResponse) FetchPostsBetween(p *pgxpool.Pool, ctx context.Context,
 }
 
 func (pr *PostResponse) FetchPostsByAuthor(p *pgxpool.Pool, ctx context.Context, categoryID, authorID int, published bool) error {
-	rows, _ := p.Query(context.Background(), `SELECT * FROM  "Post" WHERE "categoryId" = $1 AND published = $2 AND "authorId" = $3`, categoryID, published, authorID)
+	viewerID, _ := UserFromContext(ctx)
+
+	rows, _ := p.Query(context.Background(), `SELECT * FROM  "Post" WHERE "categoryId" = $1 AND published = $2 AND "authorId" = $3 AND `+postVisible(`"Post"`, "$4"), categoryID, published, authorID, viewerID)
 
 	result, err := pgx.CollectRows(rows, scanPost(p, ctx))
 	if err != nil {
@@ -503,7 +558,9 @@ func (pr *PostResponse) FetchPostsByAuthor(p *pgxpool.Pool, ctx context.Context,
 }
 
 func (pr *PostResponse) FetchPostsByAuthorBetween(p *pgxpool.Pool, ctx context.Context, categoryID, authorID int, published bool, start, end time.Time) error {
-	rows, _ := p.Query(ctx, `SELECT * FROM  "Post" WHERE "categoryId" = $1 AND published = $2 AND "authorId" = $3 AND "updatedAt" BETWEEN $4 AND $5 ORDER BY id`, categoryID, published, authorID, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	viewerID, _ := UserFromContext(ctx)
+
+	rows, _ := p.Query(ctx, `SELECT * FROM  "Post" WHERE "categoryId" = $1 AND published = $2 AND "authorId" = $3 AND "updatedAt" BETWEEN $4 AND $5 AND `+postVisible(`"Post"`, "$6")+` ORDER BY id`, categoryID, published, authorID, start.Format(time.RFC3339), end.Format(time.RFC3339), viewerID)
 
 	result, err := pgx.CollectRows(rows, scanPost(p, ctx))
 	if err != nil {
@@ -602,7 +659,9 @@ func scanPost(p *pgxpool.Pool, ctx context.Context) (fn pgx.RowToFunc[*Post]) {
 		var (
 			authorID     int
 			author       *Author
-			reactions    []*Reaction
+			reactions    []*ReactionCount
+			tags         []string
+			mentions     []*Mention
 			commentCount int
 			err          error
 			x            = &Post{}
@@ -622,8 +681,8 @@ func scanPost(p *pgxpool.Pool, ctx context.Context) (fn pgx.RowToFunc[*Post]) {
 			return nil, err
 		}
 
-		// Query all reactions of a post
-		if reactions, err = GetReacts(p, ctx, x.Id); err != nil {
+		// Query the reactions of a post per type
+		if reactions, x.MyReaction, err = GetPostReactions(p, ctx, x.Id); err != nil {
 			return nil, err
 		}
 
@@ -632,17 +691,30 @@ func scanPost(p *pgxpool.Pool, ctx context.Context) (fn pgx.RowToFunc[*Post]) {
 			return nil, err
 		}
 
+		// Query the hashtags of a post
+		if tags, err = GetPostTags(p, ctx, x.Id); err != nil {
+			return nil, err
+		}
+
+		// Query the resolved @mentions of a post
+		if mentions, err = GetMentions(p, ctx, x.Id, 0); err != nil {
+			return nil, err
+		}
+
 		// Query the total number of comments
 		if commentCount, err = GetCommentCount(p, ctx, x.Id); err != nil {
 			return nil, err
 		}
 
 		x.Reactions = reactions
+		x.Tags = tags
+		x.Mentions = mentions
 		x.Count.Comments = commentCount
 		// Store the total number of reactions
-		x.Count.Reactions = len(reactions)
-		x.Author.LastName = author.LastName
-		x.Author.FirstName = author.FirstName
+		for _, reaction := range reactions {
+			x.Count.Reactions += reaction.Count
+		}
+		x.Author = *author
 
 		return x, nil
 	}
@@ -9,6 +9,8 @@ import (
 	"strconv"
 	"strings"
 
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -18,20 +20,28 @@ type ProfileRequest struct {
 	LastName  string `json:"lastName,omitzero"`
 	Bio       string `json:"bio,omitzero"`
 	Title     string `json:"title,omitzero"`
+	IsPrivate *bool  `json:"isPrivate,omitempty"` // nil leaves the privacy setting unchanged
+	Handle    string `json:"handle,omitzero"`
+	ViewerID  int    `json:"viewerID,omitzero"` // 0 for anonymous clients of public profiles
 }
 
 type ProfileResponse struct {
 	Message string     `json:"message,omitzero"`
 	Err     error      `json:"error,omitzero"`
 	Result  []*Profile `json:"result,omitzero"`
+	MovedTo string     `json:"movedTo,omitzero"` // current handle when an old one was asked for
 }
 
 type Profile struct {
 	UserID    int           `json:"userID,omitzero"`
+	Handle    string        `json:"handle,omitzero"`
 	FirstName string        `json:"firstName,omitzero"`
 	LastName  string        `json:"lastName,omitzero"`
 	Bio       string        `json:"bio,omitzero"`
 	Title     string        `json:"title,omitzero"`
+	AvatarURL string        `json:"avatarUrl,omitzero"`
+	IsPrivate bool          `json:"isPrivate,omitzero"`
+	Avatars   []*Avatar     `json:"avatars,omitzero"`
 	Count     *ProfileCount `json:"count,omitzero"`
 }
 
@@ -92,6 +102,74 @@ func (c *Controller) Profile(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+// Handles changing the caller's handle
+func (c *Controller) ProfileHandle(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &ProfileRequest{}
+		if err := params.ParseHandle(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.PutHandle(pool, r.Context())
+		if errors.Is(err, errHandleTaken) {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusConflict)
+			return
+		} else if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// Handles public profiles by handle. Old handles redirect to the current one.
+func (c *Controller) PublicProfile(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &ProfileRequest{}
+		if err := params.ParsePublic(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.GetPublicProfile(pool, r.Context())
+		if errors.Is(err, pgx.ErrNoRows) {
+			wr.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if response.MovedTo != "" {
+			http.Redirect(wr, r, customUtil.PROFILE_ROUTE+response.MovedTo, http.StatusMovedPermanently)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
 // --------------------- Service Layer -------------------------- //
 func (pr *ProfileRequest) PutProfile(p *pgxpool.Pool, ctx context.Context) (*ProfileResponse, error) {
 	var (
@@ -125,10 +203,31 @@ func (pr *ProfileRequest) PutProfile(p *pgxpool.Pool, ctx context.Context) (*Pro
 		argPos++
 	}
 
-	query := fmt.Sprintf(`UPDATE "Profile" SET %s WHERE "userId" = %d`, strings.Join(setClause, ", "), pr.UserID)
+	if len(setClause) == 0 && pr.IsPrivate == nil {
+		return nil, errors.New("no profile field to update")
+	}
+
+	if len(setClause) > 0 {
+		query := fmt.Sprintf(`UPDATE "Profile" SET %s WHERE "userId" = %d`, strings.Join(setClause, ", "), pr.UserID)
 
-	if err := response.UpdateProfile(p, ctx, query, args...); err != nil {
-		return nil, err
+		if err := response.UpdateProfile(p, ctx, query, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	// Privacy is a setting, so it goes through the same write path as a settings PATCH
+	if pr.IsPrivate != nil {
+		privacy := customUtil.PRIVACY_PUBLIC
+		if *pr.IsPrivate {
+			privacy = customUtil.PRIVACY_PRIVATE
+		}
+
+		if err := (&SettingsResponse{}).UpdateSettings(p, ctx, pr.UserID, &SettingsPatch{Privacy: &privacy}); err != nil {
+			return nil, err
+		}
+
+		response.Err = nil
+		response.Message = "Done!"
 	}
 
 	return response, nil
@@ -157,6 +256,13 @@ func (pr *ProfileRequest) PostProfile(p *pgxpool.Pool, ctx context.Context) (*Pr
 		argPos++
 	}
 
+	if pr.IsPrivate != nil {
+		args = append(args, *pr.IsPrivate)
+		cols = append(cols, `"isPrivate"`)
+		values = append(values, fmt.Sprintf("$%d", argPos))
+		argPos++
+	}
+
 	query := fmt.Sprintf(`INSERT INTO "Profile" (%s) VALUES (%s)`, strings.Join(cols, ", "), strings.Join(values, ", "))
 
 	if err := response.CreateProfile(p, ctx, query, args...); err != nil {
@@ -168,7 +274,35 @@ func (pr *ProfileRequest) PostProfile(p *pgxpool.Pool, ctx context.Context) (*Pr
 
 func (pr *ProfileRequest) GetProfile(p *pgxpool.Pool, ctx context.Context) (*ProfileResponse, error) {
 	response := &ProfileResponse{}
-	return response, response.FetchProfile(p, ctx, pr.UserID)
+	return response, response.FetchProfileFor(p, ctx, pr.ViewerID, pr.UserID)
+}
+
+func (pr *ProfileRequest) GetPublicProfile(p *pgxpool.Pool, ctx context.Context) (*ProfileResponse, error) {
+	response := &ProfileResponse{}
+
+	if pr.Handle == "" {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchPublicProfile(p, ctx, pr.ViewerID, pr.Handle); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (pr *ProfileRequest) PutHandle(p *pgxpool.Pool, ctx context.Context) (*ProfileResponse, error) {
+	response := &ProfileResponse{}
+
+	if pr.Handle == "" || pr.UserID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.UpdateHandle(p, ctx, pr.UserID, pr.Handle); err != nil {
+		return nil, err
+	}
+
+	return response, nil
 }
 
 // --------------------- Repository Layer -------------------------- //
@@ -199,18 +333,28 @@ func (pr *ProfileResponse) CreateProfile(p *pgxpool.Pool, ctx context.Context, q
 func (pr *ProfileResponse) FetchProfile(p *pgxpool.Pool, ctx context.Context, userId int) error {
 	x := &Profile{}
 
-	err := p.QueryRow(ctx, `SELECT * FROM "Profile" WHERE  "userId" = $1`, userId).Scan(
+	err := p.QueryRow(ctx, `SELECT "userId", COALESCE("handle", ''), "firstName", "lastName", COALESCE("title", ''), COALESCE("bio", ''), COALESCE("profileUrl", ''), "isPrivate" FROM "Profile" WHERE  "userId" = $1`, userId).Scan(
 		&x.UserID,
+		&x.Handle,
 		&x.FirstName,
 		&x.LastName,
 		&x.Title,
 		&x.Bio,
+		&x.AvatarURL,
+		&x.IsPrivate,
 	)
 
 	if err != nil {
 		return err
 	}
 
+	// Empty unless the user uploaded a picture (OAuth pictures only set profileUrl)
+	if avatars, err := GetAvatars(p, ctx, x.UserID); err != nil {
+		return err
+	} else {
+		x.Avatars = avatars
+	}
+
 	if count, err := getProfileCount(p, ctx, x.UserID); err != nil {
 		return err
 	} else {
@@ -224,6 +368,141 @@ func (pr *ProfileResponse) FetchProfile(p *pgxpool.Pool, ctx context.Context, us
 	return nil
 }
 
+// Reads the profile of userID the way viewerID (0 when anonymous) may see it. Others never see pending request
+// counts, and only approved followers see the title and bio of a private profile. Users in a block with the
+// viewer are not found.
+func (pr *ProfileResponse) FetchProfileFor(p *pgxpool.Pool, ctx context.Context, viewerID, userID int) error {
+	if viewerID != userID {
+		if blocked, err := IsBlocked(p, ctx, viewerID, userID); err != nil {
+			return err
+		} else if blocked {
+			return pgx.ErrNoRows
+		}
+	}
+
+	if err := pr.FetchProfile(p, ctx, userID); err != nil {
+		return err
+	}
+
+	if viewerID == userID {
+		return nil
+	}
+
+	x := pr.Result[0]
+	x.Count.RequestsTo = 0
+	x.Count.RequestsFrom = 0
+
+	if x.IsPrivate {
+		state, err := GetFollowState(p, ctx, viewerID, userID)
+		if err != nil {
+			return err
+		}
+
+		if state != customUtil.FOLLOW_STATE_FOLLOWING {
+			x.Title = ""
+			x.Bio = ""
+		}
+	}
+
+	return nil
+}
+
+// Reads a profile by its handle. A handle the owner gave up only sets MovedTo to their current one.
+func (pr *ProfileResponse) FetchPublicProfile(p *pgxpool.Pool, ctx context.Context, viewerID int, handle string) error {
+	var userID int
+
+	// Deleted accounts and accounts pending deletion are not found, nor redirected to
+	err := p.QueryRow(ctx, `SELECT pf."userId" FROM "Profile" pf WHERE pf."handle" = $1 AND `+accountActive(`pf."userId"`), handle).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = p.QueryRow(ctx, `
+			SELECT pf."handle" FROM "ProfileHandleHistory" h
+			JOIN "Profile" pf ON pf."userId" = h."userId"
+			WHERE h."handle" = $1 AND pf."handle" IS NOT NULL AND `+accountActive(`pf."userId"`), handle).Scan(&pr.MovedTo)
+		if err != nil {
+			return err
+		}
+
+		pr.Err = nil
+		pr.Message = "Done!"
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return pr.FetchProfileFor(p, ctx, viewerID, userID)
+}
+
+// Changes the handle of userID. The old handle is kept for redirects, and a user may take back their own old handles.
+func (pr *ProfileResponse) UpdateHandle(p *pgxpool.Pool, ctx context.Context, userID int, handle string) error {
+	var (
+		current *string
+		taken   bool
+	)
+
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `SELECT "handle" FROM "Profile" WHERE "userId" = $1 FOR UPDATE`, userID).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("profile not found")
+	} else if err != nil {
+		return err
+	}
+
+	if current == nil || *current != handle {
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM "Profile" WHERE "handle" = $1 AND "userId" <> $2)
+			OR EXISTS (SELECT 1 FROM "ProfileHandleHistory" WHERE "handle" = $1 AND "userId" <> $2)`, handle, userID).Scan(&taken)
+		if err != nil {
+			return err
+		}
+
+		if taken {
+			return fmt.Errorf("%w: %q", errHandleTaken, handle)
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM ONLY "ProfileHandleHistory" WHERE "handle" = $1 AND "userId" = $2`, handle, userID); err != nil {
+			return err
+		}
+
+		if current != nil {
+			_, err = tx.Exec(ctx, `
+				INSERT INTO "ProfileHandleHistory" ("handle", "userId") VALUES ($1, $2)
+				ON CONFLICT ("handle") DO UPDATE SET "userId" = EXCLUDED."userId", "changedAt" = CURRENT_TIMESTAMP`, *current, userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The unique index still refuses a handle taken concurrently
+		if _, err := tx.Exec(ctx, `UPDATE "Profile" SET "handle" = $1 WHERE "userId" = $2`, handle, userID); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+	}
+
+	return pr.FetchProfile(p, ctx, userID)
+}
+
+// Turns the pending follow requests to userID into follows, used when the account goes public
+func ApproveFollowRequests(p *pgxpool.Pool, ctx context.Context, userID int) error {
+	_, err := p.Exec(ctx, `
+		WITH approved AS (
+			DELETE FROM ONLY "FollowRequest" WHERE "targetId" = $1 RETURNING "requesterId"
+		)
+		INSERT INTO "UserNetwork" ("followerId", "followingId")
+		SELECT "requesterId", $1 FROM approved
+		ON CONFLICT DO NOTHING`, userID)
+
+	return err
+}
+
 func getProfileCount(p *pgxpool.Pool, ctx context.Context, userId int) (*ProfileCount, error) {
 	count := &ProfileCount{}
 
@@ -232,8 +511,9 @@ func getProfileCount(p *pgxpool.Pool, ctx context.Context, userId int) (*Profile
 		return nil, err
 	}
 
-	// Get number of user reactions
-	if err := p.QueryRow(ctx, `SELECT COUNT(*) FROM "Reactions" WHERE "reactorId" = $1`, userId).Scan(&count.Reaction); err != nil {
+	// Get number of user reactions on posts and comments
+	err := p.QueryRow(ctx, `SELECT (SELECT COUNT(*) FROM "Reactions" WHERE "reactorId" = $1) + (SELECT COUNT(*) FROM "CommentReaction" WHERE "reactorId" = $1)`, userId).Scan(&count.Reaction)
+	if err != nil {
 		return nil, err
 	}
 
@@ -260,9 +540,17 @@ func getProfileCount(p *pgxpool.Pool, ctx context.Context, userId int) (*Profile
 	return count, nil
 }
 
+// Other users' profiles can be read with ?userId=, only the caller's own can be written
 func (pr *ProfileRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+
+	pr.UserID = userID
+	pr.ViewerID = userID
 
-	if userId := r.FormValue("userId"); userId != "" {
+	if userId := r.FormValue("userId"); userId != "" && (r.Method == http.MethodGet || r.Method == "") {
 		if num, err := strconv.ParseInt(userId, 10, 0); err != nil {
 			return err
 		} else {
@@ -270,30 +558,55 @@ func (pr *ProfileRequest) Parse(r *http.Request) error {
 		}
 	}
 
-	// Required
-	if userId := r.FormValue("userId"); userId != "" {
-		if num, err := strconv.ParseInt(userId, 10, 0); err != nil {
+	pr.FirstName = r.FormValue("firstName")
+	pr.LastName = r.FormValue("lastName")
+
+	// Required when creating a profile
+	if r.Method == http.MethodPost && (pr.FirstName == "" || pr.LastName == "") {
+		return errors.New("bad Request Body")
+	}
+
+	// Optional
+	pr.Title = r.FormValue("title")
+	pr.Bio = r.FormValue("bio")
+
+	if isPrivate := r.FormValue("isPrivate"); isPrivate != "" {
+		if bl, err := strconv.ParseBool(isPrivate); err != nil {
 			return err
 		} else {
-			pr.UserID = int(num)
+			pr.IsPrivate = &bl
 		}
 	}
 
-	if firstName := r.FormValue("firstName"); firstName == "" {
-		return errors.New("bad Request Body")
-	} else {
-		pr.FirstName = firstName
+	return nil
+}
+
+func (pr *ProfileRequest) ParseHandle(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
 	}
+	pr.UserID = userID
 
-	if lastName := r.FormValue("lastName"); lastName == "" {
-		return errors.New("bad Request Body")
-	} else {
-		pr.LastName = lastName
+	handle, err := customUtil.NormalizeHandle(r.FormValue("handle"))
+	if err != nil {
+		return err
 	}
+	pr.Handle = handle
 
-	// Optional
-	pr.Title = r.FormValue("title")
-	pr.Bio = r.FormValue("bio")
+	return nil
+}
+
+// The viewer is optional on public profiles
+func (pr *ProfileRequest) ParsePublic(r *http.Request) error {
+	pr.ViewerID, _ = UserFromContext(r.Context())
+
+	// Lookups are case-insensitive like handles themselves, but not validated so old or reserved ones still resolve
+	pr.Handle = strings.ToLower(strings.TrimPrefix(r.PathValue("handle"), "@"))
 
 	return nil
 }
+
+// --------------------- Utility Layer -------------------------- //
+
+var errHandleTaken = errors.New("handle is taken")
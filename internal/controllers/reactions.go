@@ -8,8 +8,10 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	customUtil "github.com/app-clone-tod-utils"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -18,39 +20,102 @@ type Reaction struct {
 	Id        int       `json:"id,omitzero"`
 	ReactID   int       `json:"reactID,omitzero"`
 	PostID    int       `json:"postID,omitzero"`
+	CommentID int       `json:"commentID,omitzero"`
 	CreatedAt time.Time `json:"createdAt,omitzero"`
 	UpdatedAt time.Time `json:"updatedAt,omitzero"`
 	ReactorID int       `json:"reactorID,omitzero"`
 }
 
 type ReactionRequest struct {
-	Id_react  int `json:"id_react,omitzero"` // the row id
 	PostID    int `json:"postID,omitzero"`
+	CommentID int `json:"commentID,omitzero"` // reacts to a comment instead of a post when set
 	ReactorID int `json:"reactorID,omitzero"`
 	ReactID   int `json:"reactID,omitzero"` // lists what type of react from "React" table
 }
 
+// Number of reactions of one type on a post or comment
+type ReactionCount struct {
+	ReactID int    `json:"reactID,omitzero"`
+	Name    string `json:"name,omitzero"`
+	Emoji   string `json:"emoji,omitzero"`
+	Count   int    `json:"count,omitzero"`
+}
+
+// A row of the "Reacts" catalog
+type ReactType struct {
+	ID        int        `json:"id,omitzero"`
+	Name      string     `json:"name,omitzero"`
+	Emoji     string     `json:"emoji,omitzero"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"`
+}
+
+type ReactTypeRequest struct {
+	ReactID int    `json:"reactID,omitzero"`
+	Name    string `json:"name,omitzero"`
+	Emoji   string `json:"emoji,omitzero"`
+}
+
+type ReactTypeResponse struct {
+	Message string       `json:"message,omitzero"`
+	Err     error        `json:"err,omitzero"`
+	Result  []*ReactType `json:"result"`
+}
+
+// A user who reacted, as seen by the caller
+type Reactor struct {
+	UserID      int       `json:"userId,omitzero"`
+	Author      Author    `json:"author,omitzero"`
+	ReactID     int       `json:"reactID,omitzero"`
+	Name        string    `json:"name,omitzero"`
+	Emoji       string    `json:"emoji,omitzero"`
+	FollowState string    `json:"followState,omitzero"`
+	ReactedAt   time.Time `json:"reactedAt,omitzero"`
+}
+
+type ReactorRequest struct {
+	ViewerID  int `json:"viewerID,omitzero"`
+	PostID    int `json:"postID,omitzero"`
+	CommentID int `json:"commentID,omitzero"`
+	ReactID   int `json:"reactID,omitzero"` // only lists reactions of this type when set
+	Cursor    int `json:"cursor,omitzero"`  // nextCursor of the previous page
+	Limit     int `json:"limit,omitzero"`
+}
+
+type ReactorResponse struct {
+	Message    string     `json:"message,omitzero"`
+	Err        error      `json:"err,omitzero"`
+	Result     []*Reactor `json:"result"`
+	NextCursor int        `json:"nextCursor,omitzero"`
+}
+
 type ReactionResponse struct {
-	Message string `json:"message,omitzero"`
+	Message string    `json:"message,omitzero"`
+	Result  *Reaction `json:"result,omitzero"`
 }
 
 func (x *ReactionRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
 
 	switch r.Method {
-	// only one parameter is required from DEL requests
+	// DEL requests name the target in the query
 	case http.MethodDelete:
-		id_react := r.URL.Query().Get("id_react")
-		if id_react == "" {
-			return errors.New("bad request body")
-		}
-
-		num, err := strconv.ParseInt(id_react, 10, 0)
-		if err != nil {
-			return err
+		for name, target := range map[string]*int{"postID": &x.PostID, "commentID": &x.CommentID} {
+			value := r.URL.Query().Get(name)
+			if value == "" {
+				continue
+			}
+
+			num, err := strconv.ParseInt(value, 10, 0)
+			if err != nil {
+				return err
+			}
+			*target = int(num)
 		}
-		x.Id_react = int(num)
 
-	case http.MethodPost:
+	case http.MethodPost, http.MethodPut:
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			return err
@@ -59,19 +124,21 @@ func (x *ReactionRequest) Parse(r *http.Request) error {
 		if err := json.Unmarshal(body, x); err != nil {
 			return err
 		}
+	}
 
-		userID, ok := UserFromContext(r.Context())
-		if !ok || userID == 0 {
-			return errors.New("userID not found")
-		}
+	// logged-in users are always the reactor
+	x.ReactorID = userID
 
-		// logged-in users are always the reactor when creating reacts
-		x.ReactorID = userID
+	// A reaction targets exactly one post or comment
+	if (x.PostID == 0) == (x.CommentID == 0) {
+		return errors.New("bad request body")
 	}
 
 	return nil
 }
 
+// Handles the caller's reaction on a post or comment.
+// POST and PUT both set the reaction (switching its type if one exists), so repeated taps are harmless.
 func (c *Controller) Reaction(pool *pgxpool.Pool) http.HandlerFunc {
 
 	return func(wr http.ResponseWriter, r *http.Request) {
@@ -88,10 +155,10 @@ func (c *Controller) Reaction(pool *pgxpool.Pool) http.HandlerFunc {
 		}
 
 		switch method {
-		case http.MethodPost:
-			err = params.PostReact(pool, r.Context())
+		case http.MethodPost, http.MethodPut:
+			response, err = params.PutReact(pool, r.Context())
 		case http.MethodDelete:
-			err = params.DelReact(pool, r.Context())
+			response, err = params.DelReact(pool, r.Context())
 		default:
 			wr.WriteHeader(http.StatusMethodNotAllowed)
 			return
@@ -114,87 +181,550 @@ func (c *Controller) Reaction(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+// Handles the users who reacted to a post or comment
+func (c *Controller) Reactors(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &ReactorRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.GetReactors(pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// Handles the catalog of reaction types. Adding and retiring types is limited to admins by the route's chain.
+func (c *Controller) ReactionType(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		var response *ReactTypeResponse
+		var err error
+
+		params := &ReactTypeRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			response, err = params.GetReactTypes(pool, r.Context())
+		case http.MethodPost:
+			response, err = params.PostReactType(pool, r.Context())
+		case http.MethodDelete:
+			response, err = params.DelReactType(pool, r.Context())
+		default:
+			wr.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
 // --------------------- Service Layer -------------------------- //
 
-func (p *ReactionRequest) PostReact(pool *pgxpool.Pool, ctx context.Context) error {
+func (x *ReactorRequest) GetReactors(p *pgxpool.Pool, ctx context.Context) (*ReactorResponse, error) {
+	response := &ReactorResponse{}
+
+	if err := response.FetchReactors(p, ctx, x.ViewerID, x.PostID, x.CommentID, x.ReactID, x.Cursor, x.Limit); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (t *ReactTypeRequest) GetReactTypes(p *pgxpool.Pool, ctx context.Context) (*ReactTypeResponse, error) {
+	response := &ReactTypeResponse{}
+
+	if err := response.FetchReactTypes(p, ctx); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (t *ReactTypeRequest) PostReactType(p *pgxpool.Pool, ctx context.Context) (*ReactTypeResponse, error) {
+	response := &ReactTypeResponse{}
+
+	if t.Name == "" || t.Emoji == "" {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.CreateReactType(p, ctx, t.Name, t.Emoji); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (t *ReactTypeRequest) DelReactType(p *pgxpool.Pool, ctx context.Context) (*ReactTypeResponse, error) {
+	response := &ReactTypeResponse{}
+
+	if t.ReactID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.RetireReactType(p, ctx, t.ReactID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (p *ReactionRequest) PutReact(pool *pgxpool.Pool, ctx context.Context) (*ReactionResponse, error) {
 	response := &ReactionResponse{}
-	if p.ReactorID == 0 || p.PostID == 0 {
-		return errors.New("bad request body")
+
+	if p.ReactorID == 0 || p.ReactID == 0 {
+		return nil, errors.New("bad request body")
 	}
 
-	if err := response.CreateReact(pool, ctx, p.ReactID, p.ReactorID, p.PostID); err != nil {
-		return err
+	if p.CommentID != 0 {
+		if err := response.UpsertCommentReact(pool, ctx, p.ReactID, p.ReactorID, p.CommentID); err != nil {
+			return nil, err
+		}
+
+		return response, nil
 	}
 
-	return nil
+	if err := response.UpsertReact(pool, ctx, p.ReactID, p.ReactorID, p.PostID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
 }
 
-func (p *ReactionRequest) DelReact(pool *pgxpool.Pool, ctx context.Context) error {
+func (p *ReactionRequest) DelReact(pool *pgxpool.Pool, ctx context.Context) (*ReactionResponse, error) {
 	response := &ReactionResponse{}
 
-	if p.Id_react == 0 {
-		return errors.New("bad request body")
+	if p.ReactorID == 0 {
+		return nil, errors.New("bad request body")
 	}
 
-	if err := response.RemoveReact(pool, ctx, p.Id_react); err != nil {
-		return err
+	if p.CommentID != 0 {
+		if err := response.RemoveCommentReact(pool, ctx, p.CommentID, p.ReactorID); err != nil {
+			return nil, err
+		}
+
+		return response, nil
 	}
 
-	return nil
+	if err := response.RemoveReact(pool, ctx, p.PostID, p.ReactorID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
 }
 
 // --------------------- Repository Layer -------------------------- //
 
-func (p *ReactionResponse) CreateReact(pool *pgxpool.Pool, ctx context.Context, reactID, reactorID, postID int) error {
+// Sets the reaction of reactorID on a post. The unique ("reactorId", "postId") index makes
+// concurrent requests converge on one row, and only the first reaction notifies the post author.
+func (p *ReactionResponse) UpsertReact(pool *pgxpool.Pool, ctx context.Context, reactID, reactorID, postID int) error {
+	var (
+		authorID int
+		inserted bool
+	)
+
+	// The post author is notified, and posts reactorID may not see are not found
+	err := pool.QueryRow(ctx, `SELECT p."authorId" FROM "Post" p WHERE p.id = $1 AND `+postVisible("p", "$2"), postID, reactorID).Scan(&authorID)
+	if err != nil {
+		return err
+	}
+
 	timeNow := time.Now()
 	x := &Reaction{}
 
-	err := pool.QueryRow(
+	// Retired types yield no row
+	err = pool.QueryRow(
 		ctx,
-		`INSERT INTO "Reactions" ("reactId", "postId","createdAt","updatedAt","reactorId") VALUES ($1, $2, $3, $4, $5) RETURNING *`,
+		`INSERT INTO "Reactions" ("reactId", "postId","createdAt","updatedAt","reactorId")
+		SELECT $1, $2, $3, $4, $5 WHERE EXISTS (SELECT 1 FROM "Reacts" WHERE "id" = $1 AND "retiredAt" IS NULL)
+		ON CONFLICT ("reactorId", "postId") DO UPDATE SET "reactId" = EXCLUDED."reactId", "updatedAt" = EXCLUDED."updatedAt"
+		RETURNING *, xmax = 0`,
 		reactID, postID, timeNow, timeNow, reactorID,
-	).Scan(&x.Id, &x.ReactID, &x.PostID, &x.CreatedAt, &x.UpdatedAt, &x.ReactorID)
+	).Scan(&x.Id, &x.ReactID, &x.PostID, &x.CreatedAt, &x.UpdatedAt, &x.ReactorID, &inserted)
 
-	if err != nil {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("unknown or retired reaction type")
+	} else if err != nil {
 		return err
 	}
 
+	// Tell the post author someone reacted
+	if inserted {
+		if err := Notify(pool, ctx, &Notification{UserID: authorID, ActorID: reactorID, Type: customUtil.NOTIFICATION_REACTION, PostID: postID}); err != nil {
+			return err
+		}
+	}
+
 	p.Message = "Done!"
+	p.Result = x
 
 	return nil
 }
 
-func (p *ReactionResponse) RemoveReact(pool *pgxpool.Pool, ctx context.Context, id_react int) error {
-	x := &Reaction{}
+// Takes back the reaction of reactorID on a post. Removing a missing reaction is not an error.
+func (p *ReactionResponse) RemoveReact(pool *pgxpool.Pool, ctx context.Context, postID, reactorID int) error {
+	if _, err := pool.Exec(ctx, `DELETE FROM ONLY "Reactions" WHERE "postId" = $1 AND "reactorId" = $2`, postID, reactorID); err != nil {
+		return err
+	}
 
-	err := pool.QueryRow(ctx, `DELETE FROM ONLY "Reactions" WHERE id = $1 RETURNING *`, id_react).Scan(&x.Id, &x.ReactID, &x.PostID, &x.CreatedAt, &x.UpdatedAt, &x.ReactorID)
+	p.Message = "Done!"
+	p.Result = nil
+	return nil
+}
+
+// Same as UpsertReact for a comment, notifying the comment author
+func (p *ReactionResponse) UpsertCommentReact(pool *pgxpool.Pool, ctx context.Context, reactID, reactorID, commentID int) error {
+	var (
+		postID, authorID int
+		inserted         bool
+	)
+
+	// The comment author is notified, so read it together with the post the comment belongs to.
+	// Comments on posts reactorID may not see are not found.
+	err := pool.QueryRow(ctx, `
+		SELECT c."postId", c."authorId" FROM "Comment" c JOIN "Post" p ON p."id" = c."postId"
+		WHERE c.id = $1 AND NOT c."isDeleted" AND `+postVisible("p", "$2"),
+		commentID, reactorID).Scan(&postID, &authorID)
 	if err != nil {
 		return err
 	}
 
+	timeNow := time.Now()
+	x := &Reaction{PostID: postID}
+
+	err = pool.QueryRow(
+		ctx,
+		`INSERT INTO "CommentReaction" ("reactId", "commentId", "reactorId", "createdAt", "updatedAt")
+		SELECT $1, $2, $3, $4, $5 WHERE EXISTS (SELECT 1 FROM "Reacts" WHERE "id" = $1 AND "retiredAt" IS NULL)
+		ON CONFLICT ("reactorId", "commentId") DO UPDATE SET "reactId" = EXCLUDED."reactId", "updatedAt" = EXCLUDED."updatedAt"
+		RETURNING *, xmax = 0`,
+		reactID, commentID, reactorID, timeNow, timeNow,
+	).Scan(&x.Id, &x.ReactID, &x.CommentID, &x.ReactorID, &x.CreatedAt, &x.UpdatedAt, &inserted)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("unknown or retired reaction type")
+	} else if err != nil {
+		return err
+	}
+
+	if inserted {
+		if err := Notify(pool, ctx, &Notification{UserID: authorID, ActorID: reactorID, Type: customUtil.NOTIFICATION_COMMENT_REACTION, PostID: postID, CommentID: commentID}); err != nil {
+			return err
+		}
+	}
+
+	p.Message = "Done!"
+	p.Result = x
+
+	return nil
+}
+
+// Same as RemoveReact for a comment
+func (p *ReactionResponse) RemoveCommentReact(pool *pgxpool.Pool, ctx context.Context, commentID, reactorID int) error {
+	if _, err := pool.Exec(ctx, `DELETE FROM ONLY "CommentReaction" WHERE "commentId" = $1 AND "reactorId" = $2`, commentID, reactorID); err != nil {
+		return err
+	}
+
 	p.Message = "Done!"
+	p.Result = nil
+	return nil
+}
+
+// Users who reacted to a post (commentID = 0) or a comment (postID = 0), latest first.
+// The cursor is the id of the last reaction sent, so reactions changing type do not move between pages.
+func (x *ReactorResponse) FetchReactors(p *pgxpool.Pool, ctx context.Context, viewerID, postID, commentID, reactID, cursor, limit int) error {
+	var nextCursor int
+
+	// The post reacted to, directly or through the comment, must be visible to viewerID
+	table, column, targetID, post := `"Reactions"`, `"postId"`, postID, `JOIN "Post" p ON p."id" = x."postId"`
+	if commentID != 0 {
+		table, column, targetID = `"CommentReaction"`, `"commentId"`, commentID
+		post = `JOIN "Comment" c ON c."id" = x."commentId" JOIN "Post" p ON p."id" = c."postId"`
+	}
+
+	// Profiles and follow states come with each row, so a page costs a single query
+	rows, _ := p.Query(ctx, `
+		SELECT x."id", x."reactorId", x."reactId", r."name", r."emoji", x."updatedAt",
+			COALESCE(pf."firstName", ''), COALESCE(pf."lastName", ''), COALESCE(pf."profileUrl", ''),
+			EXISTS (SELECT 1 FROM "UserNetwork" WHERE "followerId" = $5 AND "followingId" = x."reactorId"),
+			EXISTS (SELECT 1 FROM "FollowRequest" WHERE "requesterId" = $5 AND "targetId" = x."reactorId")
+		FROM `+table+` x JOIN "Reacts" r ON r."id" = x."reactId" `+post+`
+		LEFT JOIN "Profile" pf ON pf."userId" = x."reactorId"
+		WHERE x.`+column+` = $1 AND ($2 = 0 OR x."reactId" = $2) AND ($3 = 0 OR x."id" < $3) AND `+notBlocked(`x."reactorId"`, "$5")+`
+		AND `+postVisible("p", "$5")+`
+		ORDER BY x."id" DESC
+		LIMIT $4`, targetID, reactID, cursor, limit+1, viewerID)
+
+	ids := []int{}
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Reactor, error) {
+		var (
+			id                   int
+			following, requested bool
+		)
+		x := &Reactor{}
+
+		err := row.Scan(&id, &x.UserID, &x.ReactID, &x.Name, &x.Emoji, &x.ReactedAt,
+			&x.Author.FirstName, &x.Author.LastName, &x.Author.AvatarURL, &following, &requested)
+		if err != nil {
+			return nil, err
+		}
+
+		// Same states as GetFollowState in network.go
+		switch {
+		case x.UserID == viewerID:
+			x.FollowState = customUtil.FOLLOW_STATE_SELF
+		case following:
+			x.FollowState = customUtil.FOLLOW_STATE_FOLLOWING
+		case requested:
+			x.FollowState = customUtil.FOLLOW_STATE_REQUESTED
+		default:
+			x.FollowState = customUtil.FOLLOW_STATE_NONE
+		}
+
+		ids = append(ids, id)
+		return x, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// The extra row only tells whether another page exists
+	if len(result) > limit {
+		result = result[:limit]
+		nextCursor = ids[limit-1]
+	}
+
+	x.Err = nil
+	x.Message = "Done!"
+	x.Result = result
+	x.NextCursor = nextCursor
+
 	return nil
 }
 
-// Used by FetchPost(s) handlers in posts.go
-func GetReacts(p *pgxpool.Pool, ctx context.Context, postID int) ([]*Reaction, error) {
-	rows, _ := p.Query(ctx, `SELECT "reactorId", "reactId", id FROM "Reactions" WHERE "postId" = $1`, postID)
+// Active reaction types, in the order they were added
+func (t *ReactTypeResponse) FetchReactTypes(p *pgxpool.Pool, ctx context.Context) error {
+	rows, _ := p.Query(ctx, `SELECT "id", "name", "emoji" FROM "Reacts" WHERE "retiredAt" IS NULL ORDER BY "id"`)
 
-	result, err := pgx.CollectRows(rows, scanReaction)
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*ReactType, error) {
+		x := &ReactType{}
+		if err := row.Scan(&x.ID, &x.Name, &x.Emoji); err != nil {
+			return nil, err
+		}
+		return x, nil
+	})
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return result, nil
+	t.Err = nil
+	t.Message = "Done!"
+	t.Result = result
+
+	return nil
 }
 
-func scanReaction(row pgx.CollectableRow) (*Reaction, error) {
-	x := &Reaction{}
+// Adds a reaction type, or brings back a retired type of the same name
+func (t *ReactTypeResponse) CreateReactType(p *pgxpool.Pool, ctx context.Context, name, emoji string) error {
+	x := &ReactType{}
 
-	if err := row.Scan(&x.ReactorID, &x.ReactID, &x.Id); err != nil {
-		return nil, err
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The seed inserts explicit ids without moving the sequence, so ids are handed out here instead.
+	// The lock serializes concurrent inserts but still lets clients read the catalog.
+	if _, err := tx.Exec(ctx, `LOCK TABLE "Reacts" IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO "Reacts" ("id", "name", "emoji") VALUES ((SELECT COALESCE(MAX("id"), 0) + 1 FROM "Reacts"), $1, $2)
+		ON CONFLICT ("name") DO UPDATE SET "emoji" = EXCLUDED."emoji", "retiredAt" = NULL
+		RETURNING "id", "name", "emoji"`, name, emoji).Scan(&x.ID, &x.Name, &x.Emoji)
+
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	t.Err = nil
+	t.Message = "Done!"
+	t.Result = []*ReactType{x}
+
+	return nil
+}
+
+// Hides a reaction type from the catalog and rejects new reactions of it. Existing reactions still count.
+func (t *ReactTypeResponse) RetireReactType(p *pgxpool.Pool, ctx context.Context, reactID int) error {
+	x := &ReactType{}
+
+	err := p.QueryRow(ctx, `
+		UPDATE "Reacts" SET "retiredAt" = COALESCE("retiredAt", $1) WHERE "id" = $2
+		RETURNING "id", "name", "emoji", "retiredAt"`, time.Now(), reactID).Scan(&x.ID, &x.Name, &x.Emoji, &x.RetiredAt)
+
+	if err != nil {
+		return err
+	}
+
+	t.Err = nil
+	t.Message = "Done!"
+	t.Result = []*ReactType{x}
+
+	return nil
+}
+
+// Counts the reactions of a post per type, along with the type the user of ctx reacted with (0 if none).
+// Reactions of users in a block with the user of ctx are not counted.
+//
+// Used by scanPost in posts.go
+func GetPostReactions(p *pgxpool.Pool, ctx context.Context, postID int) ([]*ReactionCount, int, error) {
+	viewerID, _ := UserFromContext(ctx)
+
+	rows, _ := p.Query(ctx, `
+		SELECT pr."reactId", r."name", r."emoji", COUNT(*), BOOL_OR(pr."reactorId" = $2)
+		FROM "Reactions" pr JOIN "Reacts" r ON r."id" = pr."reactId"
+		WHERE pr."postId" = $1 AND `+notBlocked(`pr."reactorId"`, "$2")+`
+		GROUP BY pr."reactId", r."name", r."emoji"
+		ORDER BY pr."reactId"`, postID, viewerID)
+
+	return collectReactionCounts(rows)
+}
+
+// Same as GetPostReactions for a comment.
+//
+// Used by FetchComment and scanComment in comments.go
+func GetCommentReactions(p *pgxpool.Pool, ctx context.Context, commentID int) ([]*ReactionCount, int, error) {
+	viewerID, _ := UserFromContext(ctx)
+
+	rows, _ := p.Query(ctx, `
+		SELECT cr."reactId", r."name", r."emoji", COUNT(*), BOOL_OR(cr."reactorId" = $2)
+		FROM "CommentReaction" cr JOIN "Reacts" r ON r."id" = cr."reactId"
+		WHERE cr."commentId" = $1 AND `+notBlocked(`cr."reactorId"`, "$2")+`
+		GROUP BY cr."reactId", r."name", r."emoji"
+		ORDER BY cr."reactId"`, commentID, viewerID)
+
+	return collectReactionCounts(rows)
+}
+
+func (t *ReactTypeRequest) Parse(r *http.Request) error {
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+			return err
+		}
+	}
+
+	t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+	t.Emoji = strings.TrimSpace(t.Emoji)
+
+	if reactID := r.PathValue("reactID"); reactID != "" {
+		num, err := strconv.ParseInt(reactID, 10, 0)
+		if err != nil {
+			return err
+		}
+		t.ReactID = int(num)
+	}
+
+	return nil
+}
+
+func (x *ReactorRequest) Parse(r *http.Request) error {
+	var err error
+
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	x.ViewerID = userID
+
+	if x.PostID, err = parseIntQuery(r, "postID", 0); err != nil {
+		return err
+	}
+
+	if x.CommentID, err = parseIntQuery(r, "commentID", 0); err != nil {
+		return err
+	}
+
+	// Reactors are listed for exactly one post or comment
+	if (x.PostID == 0) == (x.CommentID == 0) {
+		return errors.New("bad request body")
+	}
+
+	if x.ReactID, err = parseIntQuery(r, "reactID", 0); err != nil {
+		return err
+	}
+
+	if x.Cursor, err = parseIntQuery(r, "cursor", 0); err != nil {
+		return err
+	}
+
+	if x.Limit, err = parseLimit(r); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Reads rows of (reactId, name, emoji, count, isMine) and picks out the caller's reaction
+func collectReactionCounts(rows pgx.Rows) ([]*ReactionCount, int, error) {
+	var mine int
+
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*ReactionCount, error) {
+		var isMine bool
+		x := &ReactionCount{}
+
+		if err := row.Scan(&x.ReactID, &x.Name, &x.Emoji, &x.Count, &isMine); err != nil {
+			return nil, err
+		}
+
+		if isMine {
+			mine = x.ReactID
+		}
+		return x, nil
+	})
+
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return x, nil
+	return result, mine, nil
 }
@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type RelationshipRequest struct {
+	UserID    int   `json:"userID,omitzero"`
+	TargetIDs []int `json:"targetIDs,omitzero"`
+	TargetID  int   `json:"targetID,omitzero"` // user whose mutual followers are listed
+	Cursor    int   `json:"cursor,omitzero"`   // id of the last user of the previous page
+	Limit     int   `json:"limit,omitzero"`
+}
+
+type RelationshipResponse struct {
+	Message string          `json:"message,omitzero"`
+	Err     error           `json:"err,omitzero"`
+	Result  []*Relationship `json:"result"`
+}
+
+// How the caller and another user relate, seen from the caller
+type Relationship struct {
+	UserID      int    `json:"userId,omitzero"`
+	State       string `json:"state,omitzero"` // follow state of the caller towards the user
+	Following   bool   `json:"following"`      // the caller follows the user
+	FollowedBy  bool   `json:"followedBy"`     // the user follows the caller
+	Requested   bool   `json:"requested"`      // the caller asked to follow the user
+	RequestedBy bool   `json:"requestedBy"`    // the user asked to follow the caller
+	Blocking    bool   `json:"blocking"`
+	BlockedBy   bool   `json:"blockedBy"`
+	Muting      bool   `json:"muting"`
+}
+
+// Handles the caller's relationship with each user of ?ids=1,2,3
+func (c *Controller) Relationships(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &RelationshipRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.GetRelationships(pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// Handles the followers of a user that the caller follows too
+func (c *Controller) MutualFollowers(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &RelationshipRequest{}
+		if err := params.ParseMutuals(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.GetMutualFollowers(pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (rr *RelationshipRequest) GetRelationships(p *pgxpool.Pool, ctx context.Context) (*RelationshipResponse, error) {
+	response := &RelationshipResponse{}
+
+	if len(rr.TargetIDs) == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchRelationships(p, ctx, rr.UserID, rr.TargetIDs); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (rr *RelationshipRequest) GetMutualFollowers(p *pgxpool.Pool, ctx context.Context) (*ProfileNetworkResponse, error) {
+	response := &ProfileNetworkResponse{}
+
+	if rr.TargetID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchMutualFollowers(p, ctx, rr.UserID, rr.TargetID, rr.Cursor, rr.Limit); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+// Relationships of userID with each of targetIDs in one query, in the order asked. Unknown users are left out.
+func (r *RelationshipResponse) FetchRelationships(p *pgxpool.Pool, ctx context.Context, userID int, targetIDs []int) error {
+	rows, _ := p.Query(ctx, `
+		SELECT t."id",
+			EXISTS (SELECT 1 FROM "UserNetwork" WHERE "followerId" = $1 AND "followingId" = t."id"),
+			EXISTS (SELECT 1 FROM "UserNetwork" WHERE "followerId" = t."id" AND "followingId" = $1),
+			EXISTS (SELECT 1 FROM "FollowRequest" WHERE "requesterId" = $1 AND "targetId" = t."id"),
+			EXISTS (SELECT 1 FROM "FollowRequest" WHERE "requesterId" = t."id" AND "targetId" = $1),
+			EXISTS (SELECT 1 FROM "UserBlock" WHERE "blockerId" = $1 AND "blockedId" = t."id"),
+			EXISTS (SELECT 1 FROM "UserBlock" WHERE "blockerId" = t."id" AND "blockedId" = $1),
+			EXISTS (SELECT 1 FROM "UserMute" WHERE "muterId" = $1 AND "mutedId" = t."id")
+		FROM unnest($2::integer[]) WITH ORDINALITY AS t("id", "position")
+		JOIN "User" u ON u."id" = t."id"
+		ORDER BY t."position"`, userID, targetIDs)
+
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Relationship, error) {
+		x := &Relationship{}
+
+		err := row.Scan(&x.UserID, &x.Following, &x.FollowedBy, &x.Requested, &x.RequestedBy, &x.Blocking, &x.BlockedBy, &x.Muting)
+		if err != nil {
+			return nil, err
+		}
+
+		// Same states as GetFollowState in network.go
+		switch {
+		case x.UserID == userID:
+			x.State = customUtil.FOLLOW_STATE_SELF
+		case x.Following:
+			x.State = customUtil.FOLLOW_STATE_FOLLOWING
+		case x.Requested:
+			x.State = customUtil.FOLLOW_STATE_REQUESTED
+		default:
+			x.State = customUtil.FOLLOW_STATE_NONE
+		}
+
+		return x, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	r.Err = nil
+	r.Message = "Done!"
+	r.Result = result
+
+	return nil
+}
+
+// Followers of targetID that viewerID follows as well, by user id. Nothing is listed when targetID is
+// private and not followed by viewerID, when either blocked the other, or when targetID is deleted.
+// Deleted followers are left out.
+func (pn *ProfileNetworkResponse) FetchMutualFollowers(p *pgxpool.Pool, ctx context.Context, viewerID, targetID, cursor, limit int) error {
+	var nextCursor string
+
+	rows, _ := p.Query(ctx, `
+		SELECT theirs."followerId", theirs."assignedAt", COALESCE(pf."firstName", ''), COALESCE(pf."lastName", ''), COALESCE(pf."profileUrl", '')
+		FROM "UserNetwork" theirs
+		JOIN "UserNetwork" mine ON mine."followingId" = theirs."followerId" AND mine."followerId" = $1
+		LEFT JOIN "Profile" pf ON pf."userId" = theirs."followerId"
+		WHERE theirs."followingId" = $2 AND ($3 = 0 OR theirs."followerId" > $3)
+		AND `+profileVisible("$2", "$1")+` AND `+notBlocked("$2", "$1")+`
+		AND `+accountActive("$2")+` AND `+accountActive(`theirs."followerId"`)+`
+		ORDER BY theirs."followerId"
+		LIMIT $4`, viewerID, targetID, cursor, limit+1)
+
+	result, err := pgx.CollectRows(rows, scanNetwork)
+	if err != nil {
+		return err
+	}
+
+	// The extra row only tells whether another page exists
+	if len(result) > limit {
+		result = result[:limit]
+		nextCursor = strconv.Itoa(result[limit-1].UserID)
+	}
+
+	pn.Err = nil
+	pn.Message = "Done!"
+	pn.Result = result
+	pn.NextCursor = nextCursor
+
+	return nil
+}
+
+// Reads ?ids=1,2,3, at most customUtil.MAX_PAGE_SIZE of them
+func (rr *RelationshipRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	rr.UserID = userID
+
+	for id := range strings.SplitSeq(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+
+		num, err := strconv.ParseInt(id, 10, 0)
+		if err != nil {
+			return err
+		}
+		rr.TargetIDs = append(rr.TargetIDs, int(num))
+	}
+
+	if len(rr.TargetIDs) > customUtil.MAX_PAGE_SIZE {
+		return fmt.Errorf("at most %d ids can be asked at once", customUtil.MAX_PAGE_SIZE)
+	}
+
+	return nil
+}
+
+func (rr *RelationshipRequest) ParseMutuals(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	rr.UserID = userID
+
+	targetID, err := strconv.ParseInt(r.PathValue("userID"), 10, 0)
+	if err != nil {
+		return err
+	}
+	rr.TargetID = int(targetID)
+
+	if rr.Cursor, err = parseIntQuery(r, "cursor", 0); err != nil {
+		return err
+	}
+
+	if rr.Limit, err = parseLimit(r); err != nil {
+		return err
+	}
+
+	return nil
+}
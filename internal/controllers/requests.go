@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"time"
 
+	customUtil "github.com/app-clone-tod-utils"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -33,6 +34,7 @@ type FollowNetwork struct {
 	RequesterName Author    `json:"requesterName,omitzero"`
 	RequesterID   int       `json:"requesterId,omitzero"`
 	CreatedAt     time.Time `json:"createdAt,omitzero"`
+	State         string    `json:"state,omitzero"` // requested, or following when the target is public
 }
 
 func (c *Controller) Request(pool *pgxpool.Pool) http.HandlerFunc {
@@ -78,8 +80,63 @@ func (c *Controller) Request(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+// Handles the caller accepting a follow request addressed to them
+func (c *Controller) AcceptRequest(pool *pgxpool.Pool) http.HandlerFunc {
+	return requestActionHandler(pool, (*FollowNetworkRequest).AcceptFollowNetwork)
+}
+
+// Handles the caller declining a follow request addressed to them
+func (c *Controller) DeclineRequest(pool *pgxpool.Pool) http.HandlerFunc {
+	return requestActionHandler(pool, (*FollowNetworkRequest).DeclineFollowNetwork)
+}
+
+func requestActionHandler(pool *pgxpool.Pool, fn func(*FollowNetworkRequest, *pgxpool.Pool, context.Context) (*FollowNetworkResponse, error)) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &FollowNetworkRequest{}
+		if err := params.ParseAction(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := fn(params, pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
 // --------------------- Service Layer -------------------------- //
 
+// Reads the requester from the path. The caller is always the target, so only requests to them can be answered.
+func (p *FollowNetworkRequest) ParseAction(r *http.Request) error {
+	requesterID, err := strconv.ParseInt(r.PathValue("requesterID"), 10, 0)
+	if err != nil {
+		return err
+	}
+	p.RequesterID = int(requesterID)
+
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	p.UserID = userID
+	p.TargetID = userID
+
+	return nil
+}
+
 func (p *FollowNetworkRequest) Parse(r *http.Request) error {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -160,18 +217,95 @@ func (p *FollowNetworkRequest) DelFollowNetwork(pool *pgxpool.Pool, ctx context.
 	return response, nil
 }
 
+func (p *FollowNetworkRequest) AcceptFollowNetwork(pool *pgxpool.Pool, ctx context.Context) (*FollowNetworkResponse, error) {
+	response := &FollowNetworkResponse{}
+
+	if p.RequesterID == 0 || p.TargetID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.AnswerFollowNetwork(pool, ctx, p.TargetID, p.RequesterID, true); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (p *FollowNetworkRequest) DeclineFollowNetwork(pool *pgxpool.Pool, ctx context.Context) (*FollowNetworkResponse, error) {
+	response := &FollowNetworkResponse{}
+
+	if p.RequesterID == 0 || p.TargetID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.AnswerFollowNetwork(pool, ctx, p.TargetID, p.RequesterID, false); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
 // --------------------- Repository Layer -------------------------- //
 
-func (r *FollowNetworkResponse) CreateFollowNetwork(p *pgxpool.Pool, ctx context.Context, targetID, requesterID int) error {
+// Removes the request of requesterID to targetID and, when accepted, makes requesterID a follower in the same transaction.
+// Either way the requester is told the outcome.
+func (r *FollowNetworkResponse) AnswerFollowNetwork(p *pgxpool.Pool, ctx context.Context, targetID, requesterID int, accept bool) error {
 	x := &FollowNetwork{
 		TargetID:    targetID,
 		RequesterID: requesterID,
 	}
 
-	err := p.QueryRow(ctx, `INSERT INTO "FollowRequest" ("requesterId", "targetId") VALUES ($1, $2) RETURNING "createdAt"`, requesterID, targetID).Scan(&x.CreatedAt)
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
+
+	// Only requests addressed to targetID match, and a request answered concurrently is gone by now
+	err = tx.QueryRow(ctx, `DELETE FROM ONLY "FollowRequest" WHERE "targetId" = $1 AND "requesterId" = $2 RETURNING "createdAt"`, targetID, requesterID).Scan(&x.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("follow request not found")
+	} else if err != nil {
+		return err
+	}
+
+	notification := &Notification{UserID: requesterID, ActorID: targetID, Type: customUtil.NOTIFICATION_FOLLOW_DECLINE}
+
+	if accept {
+		notification.Type = customUtil.NOTIFICATION_FOLLOW_ACCEPT
+
+		if _, err := tx.Exec(ctx, `INSERT INTO "UserNetwork" ("followerId", "followingId") VALUES ($1, $2) ON CONFLICT DO NOTHING`, requesterID, targetID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if err := Notify(p, ctx, notification); err != nil {
+		return err
+	}
+
+	r.Result = []*FollowNetwork{x}
+	r.Err = nil
+	r.Message = "Done!"
+	return nil
+}
+
+// Public targets are followed right away, private ones get a request to answer
+func (r *FollowNetworkResponse) CreateFollowNetwork(p *pgxpool.Pool, ctx context.Context, targetID, requesterID int) error {
+	var (
+		x = &FollowNetwork{
+			TargetID:    targetID,
+			RequesterID: requesterID,
+		}
+		err error
+	)
+
+	if x.State, x.CreatedAt, err = Follow(p, ctx, requesterID, targetID); err != nil {
+		return err
+	}
 
 	r.Result = []*FollowNetwork{x}
 	r.Err = nil
@@ -236,8 +370,7 @@ func scanFollowNetwork(p *pgxpool.Pool, ctx context.Context, fromRequests bool)
 				return nil, err
 			}
 
-			x.TargetName.FirstName = author.FirstName
-			x.TargetName.LastName = author.LastName
+			x.TargetName = *author
 
 		} else {
 			// Populate requester profile names that requests to follow client
@@ -249,8 +382,7 @@ func scanFollowNetwork(p *pgxpool.Pool, ctx context.Context, fromRequests bool)
 				return nil, err
 			}
 
-			x.RequesterName.FirstName = author.FirstName
-			x.RequesterName.LastName = author.LastName
+			x.RequesterName = *author
 		}
 
 		return x, nil
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Searched texts of a profile. Must stay identical to the expressions indexed by
+// db/migrations/0015_user_search.sql, or the trigram indexes are not used.
+const (
+	profileNameText  = `lower(COALESCE(pf."firstName", '') || ' ' || COALESCE(pf."lastName", '') || ' ' || COALESCE(pf."handle", ''))`
+	profileAboutText = `lower(COALESCE(pf."title", '') || ' ' || COALESCE(pf."bio", ''))`
+)
+
+type UserSearchRequest struct {
+	UserID     int     `json:"userID,omitzero"`
+	Query      string  `json:"query,omitzero"`
+	Cursor     string  `json:"cursor,omitzero"` // nextCursor of the previous page
+	CursorRank float64 `json:"-"`               // score of the last user of the previous page
+	CursorID   int     `json:"-"`               // id of the last user of the previous page
+	Limit      int     `json:"limit,omitzero"`
+}
+
+type UserSearchResponse struct {
+	Message    string        `json:"message,omitzero"`
+	Err        error         `json:"err,omitzero"`
+	Result     []*UserResult `json:"result"`
+	NextCursor string        `json:"nextCursor,omitzero"` // empty on the last page
+}
+
+// A user matching a search, best matches first
+type UserResult struct {
+	UserID  int     `json:"userId,omitzero"`
+	Handle  string  `json:"handle,omitzero"`
+	User    Author  `json:"user,omitzero"`
+	Title   string  `json:"title,omitzero"`   // left out for private profiles the caller does not follow
+	Mutuals int     `json:"mutuals,omitzero"` // people the caller follows who follow this user
+	Score   float64 `json:"score,omitzero"`
+}
+
+// Handles ?q= over names, handles, titles and bios
+func (c *Controller) UserSearch(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &UserSearchRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.GetUsers(pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (us *UserSearchRequest) GetUsers(p *pgxpool.Pool, ctx context.Context) (*UserSearchResponse, error) {
+	response := &UserSearchResponse{}
+
+	if us.UserID == 0 || us.Query == "" {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.SearchUsers(p, ctx, us.UserID, us.Query, us.CursorRank, us.CursorID, us.Limit); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+// Fuzzy matches query against profile names and handles, and against titles and bios of profiles viewerID
+// may see. Name matches weigh twice as much as title or bio matches, and every doubling of the people
+// viewerID follows who follow a match adds customUtil.SEARCH_NETWORK_BOOST to its score. viewerID, users
+// in a block with them and deleted or pending-deletion accounts are left out.
+//
+// The cursor is the score and id of the last user sent (cursorID = 0 for the first page), so pages
+// don't skip or repeat users when matches are added or removed in between.
+func (us *UserSearchResponse) SearchUsers(p *pgxpool.Pool, ctx context.Context, viewerID int, query string, cursorRank float64, cursorID, limit int) error {
+	var nextCursor string
+
+	rows, _ := p.Query(ctx, `
+		SELECT "id", "handle", "firstName", "lastName", "profileUrl", "title", "mutuals", "rank" FROM (
+			SELECT *, "score" + $5::float8 * log(2, 1 + "mutuals")::float8 AS "rank"
+			FROM (
+				SELECT pf."userId" AS "id", COALESCE(pf."handle", '') AS "handle",
+					pf."firstName", pf."lastName", COALESCE(pf."profileUrl", '') AS "profileUrl",
+					CASE WHEN `+profileVisible(`pf."userId"`, "$1")+` THEN COALESCE(pf."title", '') ELSE '' END AS "title",
+					(
+						SELECT COUNT(*) FROM "UserNetwork" theirs
+						JOIN "UserNetwork" mine ON mine."followingId" = theirs."followerId" AND mine."followerId" = $1
+						WHERE theirs."followingId" = pf."userId"
+					) AS "mutuals",
+					2 * word_similarity($2, `+profileNameText+`) + CASE WHEN `+profileVisible(`pf."userId"`, "$1")+`
+						THEN word_similarity($2, `+profileAboutText+`) ELSE 0 END AS "score"
+				FROM "Profile" pf
+				WHERE pf."userId" <> $1
+				AND `+accountActive(`pf."userId"`)+`
+				AND ($2 <% `+profileNameText+` OR ($2 <% `+profileAboutText+` AND `+profileVisible(`pf."userId"`, "$1")+`))
+				AND `+notBlocked(`pf."userId"`, "$1")+`
+			) matches
+		) ranked
+		WHERE ($4 = 0 OR ("rank", "id") < ($6::float8, $4))
+		ORDER BY "rank" DESC, "id" DESC
+		LIMIT $3`, viewerID, query, limit+1, cursorID, customUtil.SEARCH_NETWORK_BOOST, cursorRank)
+
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*UserResult, error) {
+		x := &UserResult{}
+
+		err := row.Scan(&x.UserID, &x.Handle, &x.User.FirstName, &x.User.LastName, &x.User.AvatarURL, &x.Title, &x.Mutuals, &x.Score)
+		if err != nil {
+			return nil, err
+		}
+
+		return x, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// The extra row only tells whether another page exists
+	if len(result) > limit {
+		result = result[:limit]
+		nextCursor = encodeSearchCursor(result[limit-1].Score, result[limit-1].UserID)
+	}
+
+	us.Err = nil
+	us.Message = "Done!"
+	us.Result = result
+	us.NextCursor = nextCursor
+
+	return nil
+}
+
+func (us *UserSearchRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	us.UserID = userID
+
+	// Handles are searched without their "@"
+	us.Query = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "@"))
+	if utf8.RuneCountInString(us.Query) < customUtil.SEARCH_MIN_QUERY_LENGTH {
+		return fmt.Errorf("search query must be at least %d characters", customUtil.SEARCH_MIN_QUERY_LENGTH)
+	}
+
+	if us.Cursor = r.URL.Query().Get("cursor"); us.Cursor != "" {
+		rank, id, err := decodeSearchCursor(us.Cursor)
+		if err != nil {
+			return err
+		}
+		us.CursorRank, us.CursorID = rank, id
+	}
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		return err
+	}
+	us.Limit = limit
+
+	return nil
+}
+
+// --------------------- Utility Layer -------------------------- //
+
+// Encodes the position of a search result as "<score>_<user id>". The score is written with as many
+// digits as it takes to read back the exact float8 the query compares against.
+func encodeSearchCursor(rank float64, userID int) string {
+	return strconv.FormatFloat(rank, 'g', -1, 64) + "_" + strconv.Itoa(userID)
+}
+
+func decodeSearchCursor(cursor string) (float64, int, error) {
+	score, id, ok := strings.Cut(cursor, "_")
+	if !ok {
+		return 0, 0, errors.New("malformed cursor")
+	}
+
+	rank, err := strconv.ParseFloat(score, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	userID, err := strconv.ParseInt(id, 10, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if userID <= 0 {
+		return 0, 0, errors.New("malformed cursor")
+	}
+
+	return rank, int(userID), nil
+}
@@ -0,0 +1,423 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SettingsRequest struct {
+	UserID int            `json:"userID,omitzero"`
+	Patch  *SettingsPatch `json:"patch,omitzero"`
+}
+
+// A parsed JSON merge patch (RFC 7396) of Settings. Absent settings are left alone and null resets a
+// setting to its default.
+type SettingsPatch struct {
+	Preferences        map[string]*string // nil values are removed from "UserSettings"."preferences"
+	Notifications      map[string]*bool   // nil values fall back to enabled
+	ResetNotifications bool               // "notifications": null
+	Privacy            *string
+	DigestFrequency    *string
+}
+
+type SettingsResponse struct {
+	Message string    `json:"message,omitzero"`
+	Err     error     `json:"err,omitzero"`
+	Result  *Settings `json:"result,omitzero"`
+}
+
+// Effective settings of a user: what they chose, and the defaults for everything else
+type Settings struct {
+	Locale                string          `json:"locale"`
+	Timezone              string          `json:"timezone"`
+	Theme                 string          `json:"theme"`
+	DefaultPostVisibility string          `json:"defaultPostVisibility"`
+	Privacy               string          `json:"privacy"`
+	DigestFrequency       string          `json:"digestFrequency"`
+	Notifications         map[string]bool `json:"notifications"` // every type in customUtil.NotificationTypes
+}
+
+// Validators of the settings a patch may change, except notifications which are a nested object
+var settingValidators = map[string]func(string) error{
+	customUtil.SETTING_LOCALE:           validateLocale,
+	customUtil.SETTING_TIMEZONE:         validateTimezone,
+	customUtil.SETTING_THEME:            oneOf(customUtil.THEME_LIGHT, customUtil.THEME_DARK, customUtil.THEME_SYSTEM),
+	customUtil.SETTING_POST_VISIBILITY:  oneOf(customUtil.POST_VISIBILITY_PUBLISHED, customUtil.POST_VISIBILITY_DRAFT),
+	customUtil.SETTING_PRIVACY:          oneOf(customUtil.PRIVACY_PUBLIC, customUtil.PRIVACY_PRIVATE),
+	customUtil.SETTING_DIGEST_FREQUENCY: validateDigestFrequency,
+}
+
+var errInvalidSetting = errors.New("invalid setting")
+
+// Handles the caller's settings: GET returns the effective settings, PATCH takes a JSON merge patch of them
+func (c *Controller) Settings(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		var (
+			response *SettingsResponse
+			err      error
+		)
+
+		params := &SettingsRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			response, err = params.GetSettings(pool, r.Context())
+		case http.MethodPatch:
+			response, err = params.PatchSettings(pool, r.Context())
+		default:
+			wr.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch {
+		case errors.Is(err, errInvalidSetting):
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		case err != nil:
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (s *SettingsRequest) GetSettings(p *pgxpool.Pool, ctx context.Context) (*SettingsResponse, error) {
+	response := &SettingsResponse{}
+
+	if s.UserID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchSettings(p, ctx, s.UserID); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (s *SettingsRequest) PatchSettings(p *pgxpool.Pool, ctx context.Context) (*SettingsResponse, error) {
+	response := &SettingsResponse{}
+
+	if s.UserID == 0 || s.Patch == nil {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.UpdateSettings(p, ctx, s.UserID, s.Patch); err != nil {
+		return nil, err
+	}
+
+	// Nobody has to wait for approval to follow a public account
+	if s.Patch.Privacy != nil && *s.Patch.Privacy == customUtil.PRIVACY_PUBLIC {
+		if err := ApproveFollowRequests(p, ctx, s.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+// Reads the settings of userID from "UserSettings", "NotificationPreference" and "Profile"."isPrivate".
+// Stored preferences that are no longer valid (e.g. a dropped locale) read as their default.
+func (s *SettingsResponse) FetchSettings(p *pgxpool.Pool, ctx context.Context, userID int) error {
+	var (
+		preferences   map[string]string
+		notifications map[string]bool
+		isPrivate     bool
+		x             = &Settings{Notifications: map[string]bool{}}
+	)
+
+	err := p.QueryRow(ctx, `
+		SELECT COALESCE(s."preferences", '{}'::jsonb), COALESCE(s."digestFrequency", $2), COALESCE(pf."isPrivate", false),
+			COALESCE((SELECT jsonb_object_agg(np."type", np."enabled") FROM "NotificationPreference" np WHERE np."userId" = u."id"), '{}'::jsonb)
+		FROM "User" u
+		LEFT JOIN "UserSettings" s ON s."userId" = u."id"
+		LEFT JOIN "Profile" pf ON pf."userId" = u."id"
+		WHERE u."id" = $1`,
+		userID, customUtil.DIGEST_DEFAULT_FREQUENCY,
+	).Scan(&preferences, &x.DigestFrequency, &isPrivate, &notifications)
+
+	if err != nil {
+		return err
+	}
+
+	for key, fallback := range customUtil.PreferenceDefaults {
+		value, ok := preferences[key]
+		if !ok || settingValidators[key](value) != nil {
+			value = fallback
+		}
+		x.setPreference(key, value)
+	}
+
+	x.Privacy = customUtil.PRIVACY_PUBLIC
+	if isPrivate {
+		x.Privacy = customUtil.PRIVACY_PRIVATE
+	}
+
+	for _, notificationType := range customUtil.NotificationTypes {
+		enabled, ok := notifications[notificationType]
+		x.Notifications[notificationType] = enabled || !ok
+	}
+
+	s.Err = nil
+	s.Message = "Done!"
+	s.Result = x
+
+	return nil
+}
+
+// Applies an already validated patch in one transaction, then reads back the effective settings. This is
+// the one write path of the settings: the digest and profile endpoints delegate to it as well.
+func (s *SettingsResponse) UpdateSettings(p *pgxpool.Pool, ctx context.Context, userID int, patch *SettingsPatch) error {
+	var (
+		set    = map[string]string{}
+		remove = []string{}
+		now    = time.Now()
+	)
+
+	for key, value := range patch.Preferences {
+		if value == nil {
+			remove = append(remove, key)
+		} else {
+			set[key] = *value
+		}
+	}
+
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if len(patch.Preferences) > 0 || patch.DigestFrequency != nil {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO "UserSettings" ("userId", "preferences", "digestFrequency", "updatedAt")
+			VALUES ($1, $2::jsonb - $3::text[], COALESCE($4, $5), $6)
+			ON CONFLICT ("userId") DO UPDATE SET
+				"preferences" = ("UserSettings"."preferences" || $2::jsonb) - $3::text[],
+				"digestFrequency" = COALESCE($4, "UserSettings"."digestFrequency"),
+				"updatedAt" = EXCLUDED."updatedAt"`,
+			userID, set, remove, patch.DigestFrequency, customUtil.DIGEST_DEFAULT_FREQUENCY, now)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if patch.ResetNotifications {
+		if _, err := tx.Exec(ctx, `DELETE FROM ONLY "NotificationPreference" WHERE "userId" = $1`, userID); err != nil {
+			return err
+		}
+	}
+
+	for notificationType, enabled := range patch.Notifications {
+		// A missing row means the type is enabled
+		if enabled == nil {
+			_, err = tx.Exec(ctx, `DELETE FROM ONLY "NotificationPreference" WHERE "userId" = $1 AND "type" = $2`, userID, notificationType)
+		} else {
+			_, err = tx.Exec(ctx, `
+				INSERT INTO "NotificationPreference" ("userId", "type", "enabled", "updatedAt") VALUES ($1, $2, $3, $4)
+				ON CONFLICT ("userId", "type") DO UPDATE SET "enabled" = EXCLUDED."enabled", "updatedAt" = EXCLUDED."updatedAt"`,
+				userID, notificationType, *enabled, now)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if patch.Privacy != nil {
+		result, err := tx.Exec(ctx, `UPDATE "Profile" SET "isPrivate" = $2 WHERE "userId" = $1`, userID, *patch.Privacy == customUtil.PRIVACY_PRIVATE)
+		if err != nil {
+			return err
+		}
+
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("%w: privacy needs a profile", errInvalidSetting)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	// Nobody has to wait for approval to follow a public account
+	if patch.Privacy != nil && *patch.Privacy == customUtil.PRIVACY_PUBLIC {
+		if err := ApproveFollowRequests(p, ctx, userID); err != nil {
+			return err
+		}
+	}
+
+	return s.FetchSettings(p, ctx, userID)
+}
+
+// Reads the body of a PATCH as a JSON merge patch, rejecting unknown settings and invalid values
+func (s *SettingsRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	s.UserID = userID
+
+	if r.Method != http.MethodPatch {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return err
+	}
+
+	if fields == nil {
+		return errors.New("a merge patch of settings must be an object")
+	}
+
+	patch := &SettingsPatch{Preferences: map[string]*string{}, Notifications: map[string]*bool{}}
+
+	for key, raw := range fields {
+		if key == customUtil.SETTING_NOTIFICATIONS {
+			if err := patch.parseNotifications(raw); err != nil {
+				return err
+			}
+			continue
+		}
+
+		validate, ok := settingValidators[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+
+		var value *string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		if value != nil {
+			if err := validate(*value); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+
+		switch key {
+		case customUtil.SETTING_PRIVACY:
+			patch.Privacy = defaultIfNil(value, customUtil.PRIVACY_PUBLIC)
+		case customUtil.SETTING_DIGEST_FREQUENCY:
+			patch.DigestFrequency = defaultIfNil(value, customUtil.DIGEST_DEFAULT_FREQUENCY)
+		default:
+			patch.Preferences[key] = value
+		}
+	}
+
+	s.Patch = patch
+
+	return nil
+}
+
+func (sp *SettingsPatch) parseNotifications(raw json.RawMessage) error {
+	var notifications map[string]*bool
+
+	if err := json.Unmarshal(raw, &notifications); err != nil {
+		return fmt.Errorf("%s: %w", customUtil.SETTING_NOTIFICATIONS, err)
+	}
+
+	// null rather than an object
+	if notifications == nil {
+		sp.ResetNotifications = true
+		return nil
+	}
+
+	for notificationType, enabled := range notifications {
+		if !slices.Contains(customUtil.NotificationTypes, notificationType) {
+			return fmt.Errorf("unknown notification type %q", notificationType)
+		}
+		sp.Notifications[notificationType] = enabled
+	}
+
+	return nil
+}
+
+// --------------------- Utility Layer -------------------------- //
+
+func (x *Settings) setPreference(key, value string) {
+	switch key {
+	case customUtil.SETTING_LOCALE:
+		x.Locale = value
+	case customUtil.SETTING_TIMEZONE:
+		x.Timezone = value
+	case customUtil.SETTING_THEME:
+		x.Theme = value
+	case customUtil.SETTING_POST_VISIBILITY:
+		x.DefaultPostVisibility = value
+	}
+}
+
+func oneOf(allowed ...string) func(string) error {
+	return func(value string) error {
+		if !slices.Contains(allowed, value) {
+			return fmt.Errorf("%q is not one of %v", value, allowed)
+		}
+		return nil
+	}
+}
+
+func validateLocale(locale string) error {
+	return oneOf(customUtil.SupportedLocales...)(locale)
+}
+
+// IANA names only, e.g. "Europe/Paris"
+func validateTimezone(timezone string) error {
+	if timezone == "" || timezone == "Local" {
+		return fmt.Errorf("unknown timezone %q", timezone)
+	}
+
+	_, err := time.LoadLocation(timezone)
+	return err
+}
+
+func validateDigestFrequency(frequency string) error {
+	if _, ok := customUtil.DigestPeriods[frequency]; !ok && frequency != customUtil.DIGEST_OFF {
+		return fmt.Errorf("unknown digest frequency %q", frequency)
+	}
+	return nil
+}
+
+func defaultIfNil(value *string, fallback string) *string {
+	if value == nil {
+		return &fallback
+	}
+	return value
+}
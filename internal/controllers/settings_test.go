@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	customUtil "github.com/app-clone-tod-utils"
+)
+
+func TestSettingsRequestParse(t *testing.T) {
+	str := func(s string) *string { return &s }
+	boolean := func(b bool) *bool { return &b }
+
+	tests := []struct {
+		name    string
+		body    string
+		want    *SettingsPatch
+		wantErr bool
+	}{
+		{
+			name: "empty patch changes nothing",
+			body: `{}`,
+			want: &SettingsPatch{Preferences: map[string]*string{}, Notifications: map[string]*bool{}},
+		},
+		{
+			name: "preferences are set",
+			body: `{"theme": "dark", "locale": "fr", "timezone": "Europe/Paris", "defaultPostVisibility": "draft"}`,
+			want: &SettingsPatch{
+				Preferences: map[string]*string{
+					customUtil.SETTING_THEME:           str(customUtil.THEME_DARK),
+					customUtil.SETTING_LOCALE:          str("fr"),
+					customUtil.SETTING_TIMEZONE:        str("Europe/Paris"),
+					customUtil.SETTING_POST_VISIBILITY: str(customUtil.POST_VISIBILITY_DRAFT),
+				},
+				Notifications: map[string]*bool{},
+			},
+		},
+		{
+			name: "null removes a preference",
+			body: `{"theme": null}`,
+			want: &SettingsPatch{Preferences: map[string]*string{customUtil.SETTING_THEME: nil}, Notifications: map[string]*bool{}},
+		},
+		{
+			name: "null resets privacy and digests to their defaults",
+			body: `{"privacy": null, "digestFrequency": null}`,
+			want: &SettingsPatch{
+				Preferences:     map[string]*string{},
+				Notifications:   map[string]*bool{},
+				Privacy:         str(customUtil.PRIVACY_PUBLIC),
+				DigestFrequency: str(customUtil.DIGEST_DEFAULT_FREQUENCY),
+			},
+		},
+		{
+			name: "privacy and digests are set",
+			body: `{"privacy": "private", "digestFrequency": "off"}`,
+			want: &SettingsPatch{
+				Preferences:     map[string]*string{},
+				Notifications:   map[string]*bool{},
+				Privacy:         str(customUtil.PRIVACY_PRIVATE),
+				DigestFrequency: str(customUtil.DIGEST_OFF),
+			},
+		},
+		{
+			name: "notification types are merged one by one",
+			body: `{"notifications": {"follow": false, "mention": true, "reaction": null}}`,
+			want: &SettingsPatch{
+				Preferences: map[string]*string{},
+				Notifications: map[string]*bool{
+					customUtil.NOTIFICATION_FOLLOW:   boolean(false),
+					customUtil.NOTIFICATION_MENTION:  boolean(true),
+					customUtil.NOTIFICATION_REACTION: nil,
+				},
+			},
+		},
+		{
+			name: "null notifications resets all of them",
+			body: `{"notifications": null}`,
+			want: &SettingsPatch{Preferences: map[string]*string{}, Notifications: map[string]*bool{}, ResetNotifications: true},
+		},
+		{name: "unknown setting", body: `{"fontSize": "12"}`, wantErr: true},
+		{name: "unknown theme", body: `{"theme": "sepia"}`, wantErr: true},
+		{name: "unsupported locale", body: `{"locale": "xx"}`, wantErr: true},
+		{name: "unknown timezone", body: `{"timezone": "Mars/Olympus"}`, wantErr: true},
+		{name: "server local timezone", body: `{"timezone": "Local"}`, wantErr: true},
+		{name: "unknown digest frequency", body: `{"digestFrequency": "hourly"}`, wantErr: true},
+		{name: "non-string preference", body: `{"theme": 1}`, wantErr: true},
+		{name: "unknown notification type", body: `{"notifications": {"poke": false}}`, wantErr: true},
+		{name: "notifications not an object", body: `{"notifications": true}`, wantErr: true},
+		{name: "patch not an object", body: `null`, wantErr: true},
+		{name: "patch is an array", body: `[]`, wantErr: true},
+		{name: "malformed json", body: `{"theme": `, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/users/settings/", strings.NewReader(tt.body))
+			r = r.WithContext(NewUserContext(r.Context(), 1))
+
+			s := &SettingsRequest{}
+			err := s.Parse(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%s) error = %v, wantErr %v", tt.body, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if s.UserID != 1 {
+				t.Errorf("Parse(%s) UserID = %d, want 1", tt.body, s.UserID)
+			}
+			if !reflect.DeepEqual(s.Patch, tt.want) {
+				t.Errorf("Parse(%s) = %+v, want %+v", tt.body, s.Patch, tt.want)
+			}
+		})
+	}
+}
+
+func TestSettingsRequestParseWithoutUser(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPatch, "/users/settings/", strings.NewReader(`{}`))
+
+	if err := (&SettingsRequest{}).Parse(r); err == nil {
+		t.Error("Parse without a user in the context should fail")
+	}
+}
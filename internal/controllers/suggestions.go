@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SuggestionRequest struct {
+	UserID int `json:"userID,omitzero"`
+	Limit  int `json:"limit,omitzero"`
+}
+
+type SuggestionResponse struct {
+	Message string        `json:"message,omitzero"`
+	Err     error         `json:"err,omitzero"`
+	Result  []*Suggestion `json:"result"`
+}
+
+// A user the caller does not follow yet, reached through the people they follow
+type Suggestion struct {
+	UserID       int       `json:"userId,omitzero"`
+	User         Author    `json:"user,omitzero"`
+	Mutuals      int       `json:"mutuals,omitzero"`      // people the caller follows who follow this user
+	SharedTopics int       `json:"sharedTopics,omitzero"` // categories and tags both users posted in
+	FollowedBy   []*Author `json:"followedBy,omitzero"`   // latest mutuals, at most customUtil.NOTIFICATION_GROUP_ACTORS
+	Reason       string    `json:"reason,omitzero"`
+}
+
+// Handles "people you may know"
+func (c *Controller) Suggestions(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &SuggestionRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.GetSuggestions(pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (s *SuggestionRequest) GetSuggestions(p *pgxpool.Pool, ctx context.Context) (*SuggestionResponse, error) {
+	response := &SuggestionResponse{}
+
+	if s.UserID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchSuggestions(p, ctx, s.UserID, s.Limit); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+// Ranks friends-of-friends of userID by mutual connections and shared categories or tags, leaving out users
+// userID already follows or requested to follow, users in a block with them and deleted or pending-deletion
+// accounts.
+//
+// Only the latest customUtil.SUGGESTION_GRAPH_SAMPLE follows of userID are walked, and only the
+// customUtil.SUGGESTION_CANDIDATES candidates with the most mutuals are scored on topics, so the cost stays
+// bounded for users with thousands of edges.
+func (s *SuggestionResponse) FetchSuggestions(p *pgxpool.Pool, ctx context.Context, userID, limit int) error {
+	rows, _ := p.Query(ctx, `
+		WITH following AS (
+			SELECT "followingId" AS "id" FROM "UserNetwork"
+			WHERE "followerId" = $1
+			ORDER BY "assignedAt" DESC
+			LIMIT $2
+		), candidates AS (
+			SELECT n."followingId" AS "id", COUNT(*) AS "mutuals",
+				(array_agg(n."followerId" ORDER BY n."assignedAt" DESC))[1:$5] AS "via"
+			FROM following f
+			JOIN "UserNetwork" n ON n."followerId" = f."id"
+			WHERE n."followingId" <> $1
+			AND NOT EXISTS (SELECT 1 FROM "UserNetwork" un WHERE un."followerId" = $1 AND un."followingId" = n."followingId")
+			AND NOT EXISTS (SELECT 1 FROM "FollowRequest" fr WHERE fr."requesterId" = $1 AND fr."targetId" = n."followingId")
+			AND `+notBlocked("$1", `n."followingId"`)+` AND `+accountActive(`n."followingId"`)+`
+			GROUP BY n."followingId"
+			ORDER BY COUNT(*) DESC, n."followingId"
+			LIMIT $3
+		), topics AS (
+			SELECT DISTINCT 'c' || p."categoryId" AS "topic" FROM "Post" p
+			WHERE p."authorId" = $1 AND p."categoryId" IS NOT NULL AND NOT p."isDeleted"
+			UNION
+			SELECT DISTINCT 't' || pt."tagId" FROM "PostTag" pt JOIN "Post" p ON p."id" = pt."postId"
+			WHERE p."authorId" = $1 AND NOT p."isDeleted"
+		)
+		SELECT "id", "mutuals", "via", "shared" FROM (
+			SELECT c."id", c."mutuals", c."via", (
+				SELECT COUNT(*) FROM (
+					SELECT 'c' || p."categoryId" AS "topic" FROM "Post" p
+					WHERE p."authorId" = c."id" AND p."categoryId" IS NOT NULL AND NOT p."isDeleted"
+					UNION
+					SELECT 't' || pt."tagId" FROM "PostTag" pt JOIN "Post" p ON p."id" = pt."postId"
+					WHERE p."authorId" = c."id" AND NOT p."isDeleted"
+				) theirs WHERE theirs."topic" IN (SELECT "topic" FROM topics)
+			) AS "shared"
+			FROM candidates c
+		) ranked
+		ORDER BY "mutuals" * $6 + "shared" DESC, "id"
+		LIMIT $4`,
+		userID, customUtil.SUGGESTION_GRAPH_SAMPLE, customUtil.SUGGESTION_CANDIDATES, limit, customUtil.NOTIFICATION_GROUP_ACTORS, customUtil.SUGGESTION_MUTUAL_WEIGHT)
+
+	result, err := pgx.CollectRows(rows, scanSuggestion(p, ctx))
+	if err != nil {
+		return err
+	}
+
+	s.Err = nil
+	s.Message = "Done!"
+	s.Result = result
+
+	return nil
+}
+
+func scanSuggestion(p *pgxpool.Pool, ctx context.Context) pgx.RowToFunc[*Suggestion] {
+	return func(row pgx.CollectableRow) (*Suggestion, error) {
+		var (
+			via []int
+			x   = &Suggestion{}
+		)
+
+		if err := row.Scan(&x.UserID, &x.Mutuals, &via, &x.SharedTopics); err != nil {
+			return nil, err
+		}
+
+		author, err := FetchAuthor(p, ctx, x.UserID)
+		if err != nil {
+			return nil, err
+		}
+		x.User = *author
+
+		for _, id := range via {
+			mutual, err := FetchAuthor(p, ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			x.FollowedBy = append(x.FollowedBy, mutual)
+		}
+
+		x.Reason = explainSuggestion(x)
+
+		return x, nil
+	}
+}
+
+func (s *SuggestionRequest) Parse(r *http.Request) error {
+	userID, ok := UserFromContext(r.Context())
+	if !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+	s.UserID = userID
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		return err
+	}
+	s.Limit = limit
+
+	return nil
+}
+
+// --------------------- Utility Layer -------------------------- //
+
+// e.g. "Followed by Ada Lovelace and 3 others"
+func explainSuggestion(x *Suggestion) string {
+	if len(x.FollowedBy) == 0 {
+		return ""
+	}
+
+	name := strings.TrimSpace(x.FollowedBy[0].FirstName + " " + x.FollowedBy[0].LastName)
+
+	switch x.Mutuals {
+	case 1:
+		return "Followed by " + name
+	case 2:
+		return "Followed by " + name + " and 1 other"
+	default:
+		return fmt.Sprintf("Followed by %s and %d others", name, x.Mutuals-1)
+	}
+}
@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TagRequest struct {
+	Name   string        `json:"name,omitzero"`
+	Query  string        `json:"q,omitzero"`
+	Cursor int           `json:"cursor,omitzero"` // id of the last post of the previous page
+	Limit  int           `json:"limit,omitzero"`
+	Window time.Duration `json:"window,omitzero"`
+}
+
+type TagResponse struct {
+	Message string `json:"message,omitzero"`
+	Err     error  `json:"err,omitzero"`
+	Result  []*Tag `json:"result"`
+}
+
+type Tag struct {
+	Name          string  `json:"name,omitzero"`
+	Posts         int     `json:"posts,omitzero"`
+	RecentCount   int     `json:"recentCount,omitzero"`
+	PreviousCount int     `json:"previousCount,omitzero"`
+	Velocity      float64 `json:"velocity,omitzero"` // change in uses per hour between the two windows
+}
+
+// Handles tag autocomplete
+func (c *Controller) Tag(pool *pgxpool.Pool) http.HandlerFunc {
+	return tagHandler(pool, (*TagRequest).GetTags)
+}
+
+// Handles trending tags
+func (c *Controller) TrendingTags(pool *pgxpool.Pool) http.HandlerFunc {
+	return tagHandler(pool, (*TagRequest).GetTrendingTags)
+}
+
+// Handles posts of a single tag
+func (c *Controller) TagPosts(pool *pgxpool.Pool) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &TagRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.GetTagPosts(pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+func tagHandler(pool *pgxpool.Pool, fn func(*TagRequest, *pgxpool.Pool, context.Context) (*TagResponse, error)) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &TagRequest{}
+		if err := params.Parse(r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := fn(params, pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (t *TagRequest) GetTags(p *pgxpool.Pool, ctx context.Context) (*TagResponse, error) {
+	response := &TagResponse{}
+
+	if t.Query == "" {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchTagsByPrefix(p, ctx, t.Query, t.Limit); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (t *TagRequest) GetTrendingTags(p *pgxpool.Pool, ctx context.Context) (*TagResponse, error) {
+	response := &TagResponse{}
+
+	if err := response.FetchTrendingTags(p, ctx, time.Now(), t.Window, t.Limit); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (t *TagRequest) GetTagPosts(p *pgxpool.Pool, ctx context.Context) (*PostResponse, error) {
+	response := &PostResponse{}
+
+	if t.Name == "" {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchPostsByTag(p, ctx, t.Name, t.Cursor, t.Limit); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+func (t *TagResponse) FetchTagsByPrefix(p *pgxpool.Pool, ctx context.Context, prefix string, limit int) error {
+	viewerID, _ := UserFromContext(ctx)
+
+	// Most used tags first, counting only posts the viewer can see so drafts and hidden profiles don't leak their tags
+	rows, _ := p.Query(ctx, `
+		SELECT t."name", COUNT(pt."postId")
+		FROM "Tag" t
+		JOIN "PostTag" pt ON pt."tagId" = t."id"
+		JOIN "Post" p ON p."id" = pt."postId"
+		WHERE t."name" LIKE $1 || '%' AND p."published" = true AND p."isDeleted" = false AND `+postVisible("p", "$3")+`
+		GROUP BY t."name"
+		ORDER BY COUNT(pt."postId") DESC, t."name"
+		LIMIT $2`, escapeLike(prefix), limit, viewerID)
+
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Tag, error) {
+		x := &Tag{}
+		if err := row.Scan(&x.Name, &x.Posts); err != nil {
+			return nil, err
+		}
+		return x, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	t.Err = nil
+	t.Message = "Done!"
+	t.Result = result
+
+	return nil
+}
+
+// Compares how often each tag was used in the latest window against the window before it.
+// Both windows slide with now, so the ranking reflects acceleration rather than all-time popularity.
+// Only posts the viewer can see are counted.
+func (t *TagResponse) FetchTrendingTags(p *pgxpool.Pool, ctx context.Context, now time.Time, window time.Duration, limit int) error {
+	viewerID, _ := UserFromContext(ctx)
+	recentStart := now.Add(-window)
+	previousStart := now.Add(-2 * window)
+
+	rows, _ := p.Query(ctx, `
+		SELECT t."name",
+			COUNT(*) FILTER (WHERE pt."createdAt" >= $1) AS recent,
+			COUNT(*) FILTER (WHERE pt."createdAt" < $1) AS previous
+		FROM "PostTag" pt
+		JOIN "Tag" t ON t."id" = pt."tagId"
+		JOIN "Post" p ON p."id" = pt."postId"
+		WHERE pt."createdAt" >= $2 AND pt."createdAt" <= $3
+		AND p."published" = true AND p."isDeleted" = false AND `+postVisible("p", "$5")+`
+		GROUP BY t."name"
+		HAVING COUNT(*) FILTER (WHERE pt."createdAt" >= $1) > 0
+		ORDER BY COUNT(*) FILTER (WHERE pt."createdAt" >= $1) - COUNT(*) FILTER (WHERE pt."createdAt" < $1) DESC, recent DESC, t."name"
+		LIMIT $4`, recentStart, previousStart, now, limit, viewerID)
+
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Tag, error) {
+		x := &Tag{}
+		if err := row.Scan(&x.Name, &x.RecentCount, &x.PreviousCount); err != nil {
+			return nil, err
+		}
+
+		x.Velocity = float64(x.RecentCount-x.PreviousCount) / window.Hours()
+		return x, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	t.Err = nil
+	t.Message = "Done!"
+	t.Result = result
+
+	return nil
+}
+
+// Published posts of a tag, newest first. cursor is the id of the last post already sent (0 for the first page)
+func (pr *PostResponse) FetchPostsByTag(p *pgxpool.Pool, ctx context.Context, name string, cursor, limit int) error {
+	viewerID, _ := UserFromContext(ctx)
+
+	rows, _ := p.Query(ctx, `
+		SELECT p.* FROM "Post" p
+		JOIN "PostTag" pt ON pt."postId" = p."id"
+		JOIN "Tag" t ON t."id" = pt."tagId"
+		WHERE t."name" = $1 AND p."published" = true AND p."isDeleted" = false AND ($2 = 0 OR p."id" < $2) AND `+postVisible("p", "$4")+` AND `+notMuted("$4", `p."authorId"`)+`
+		ORDER BY p."id" DESC
+		LIMIT $3`, name, cursor, limit, viewerID)
+
+	result, err := pgx.CollectRows(rows, scanPost(p, ctx))
+	if err != nil {
+		return err
+	}
+
+	pr.Result = result
+	pr.Message = "Done!"
+	pr.Err = nil
+	return nil
+}
+
+// Replaces the tags of a post with tags. Tags kept across edits keep their original "createdAt".
+//
+// Used by PostPost and PutPost in posts.go
+func SetPostTags(p *pgxpool.Pool, ctx context.Context, postID int, tags []string) error {
+	tx, err := p.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM ONLY "PostTag" WHERE "postId" = $1 AND "tagId" NOT IN (SELECT "id" FROM "Tag" WHERE "name" = ANY($2))`, postID, tags)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		var tagID int
+
+		// DO UPDATE (instead of DO NOTHING) so RETURNING also yields pre-existing tags
+		err := tx.QueryRow(ctx, `INSERT INTO "Tag" ("name") VALUES ($1) ON CONFLICT ("name") DO UPDATE SET "name" = EXCLUDED."name" RETURNING "id"`, tag).Scan(&tagID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `INSERT INTO "PostTag" ("postId", "tagId") VALUES ($1, $2) ON CONFLICT DO NOTHING`, postID, tagID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Used by scanPost in posts.go
+func GetPostTags(p *pgxpool.Pool, ctx context.Context, postID int) ([]string, error) {
+	rows, _ := p.Query(ctx, `SELECT t."name" FROM "PostTag" pt JOIN "Tag" t ON t."id" = pt."tagId" WHERE pt."postId" = $1 ORDER BY t."name"`, postID)
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (t *TagRequest) Parse(r *http.Request) error {
+	if userID, ok := UserFromContext(r.Context()); !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+
+	// Accept tags with or without the leading "#"
+	t.Name = strings.ToLower(strings.TrimPrefix(r.PathValue("tag"), "#"))
+	t.Query = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "#"))
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		num, err := strconv.ParseInt(cursor, 10, 0)
+		if err != nil {
+			return err
+		}
+		t.Cursor = int(num)
+	}
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		return err
+	}
+	t.Limit = limit
+
+	t.Window = customUtil.TRENDING_WINDOW
+	if window := r.URL.Query().Get("window"); window != "" {
+		duration, err := time.ParseDuration(window)
+		if err != nil {
+			return err
+		}
+
+		if duration <= 0 {
+			return errors.New("window must be positive")
+		}
+		t.Window = duration
+	}
+
+	return nil
+}
@@ -0,0 +1,361 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	customUtil "github.com/app-clone-tod-utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type CommentTreeRequest struct {
+	PostID   int    `json:"postID,omitzero"`
+	ParentID int    `json:"parentID,omitzero"` // loads more replies of this comment instead of top-level comments
+	Cursor   int    `json:"cursor,omitzero"`   // id of the last comment of the previous page
+	Limit    int    `json:"limit,omitzero"`
+	Replies  int    `json:"replies,omitzero"` // replies per comment on nested levels
+	Depth    int    `json:"depth,omitzero"`   // reply levels nested under each returned comment
+	Sort     string `json:"sort,omitzero"`
+}
+
+type CommentTreeResponse struct {
+	Message    string         `json:"message,omitzero"`
+	Err        error          `json:"err,omitzero"`
+	Result     []*CommentNode `json:"result"`
+	NextCursor int            `json:"nextCursor,omitzero"`
+}
+
+// A comment with the first page of its replies nested under it
+type CommentNode struct {
+	Comment
+	Replies []*CommentNode `json:"replies,omitzero"`
+
+	// More replies are loaded with ?parent=<id>&cursor=<nextCursor>
+	MoreReplies bool `json:"moreReplies,omitzero"`
+	NextCursor  int  `json:"nextCursor,omitzero"`
+}
+
+// Number of reactions of comment c, used to rank the "top" sort
+const commentScore = `(SELECT COUNT(*) FROM "CommentReaction" r WHERE r."commentId" = c."id")`
+
+// ORDER BY clause and keyset condition of each sort. The cursor is always $2.
+var commentSorts = map[string]struct{ order, after string }{
+	customUtil.COMMENT_SORT_NEWEST: {`c."id" DESC`, `c."id" < $2`},
+	customUtil.COMMENT_SORT_OLDEST: {`c."id" ASC`, `c."id" > $2`},
+	customUtil.COMMENT_SORT_TOP: {
+		commentScore + ` DESC, c."id" DESC`,
+		`(` + commentScore + `, c."id") < ((SELECT COUNT(*) FROM "CommentReaction" r WHERE r."commentId" = $2), $2)`,
+	},
+}
+
+// Soft-deleted comments only stay listed while a reply below them is not deleted. Blocks are not
+// handled here: queries pair this condition with notBlocked on the comment author.
+const commentVisible = `(NOT c."isDeleted" OR EXISTS (
+	SELECT 1 FROM "Comment" d
+	WHERE d."postId" = c."postId" AND d."depth" > c."depth" AND d."path" LIKE c."path" || '%' AND NOT d."isDeleted"
+))`
+
+// Handles the comments of a post as a nested tree
+func (c *Controller) CommentTree(pool *pgxpool.Pool) http.HandlerFunc {
+	return commentTreeHandler(pool, (*CommentTreeRequest).Parse)
+}
+
+// Handles the top-level comments of a post, optionally with a preview of their first replies
+func (c *Controller) PostComments(pool *pgxpool.Pool) http.HandlerFunc {
+	return commentTreeHandler(pool, (*CommentTreeRequest).ParseList)
+}
+
+func commentTreeHandler(pool *pgxpool.Pool, parse func(*CommentTreeRequest, *http.Request) error) http.HandlerFunc {
+
+	return func(wr http.ResponseWriter, r *http.Request) {
+		params := &CommentTreeRequest{}
+		if err := parse(params, r); err != nil {
+			fmt.Printf("error (params): %s\n", err.Error())
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := params.GetCommentTree(pool, r.Context())
+		if err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if p, err := json.Marshal(response); err != nil {
+			fmt.Printf("error (internal): %s\n", err.Error())
+			wr.WriteHeader(http.StatusInternalServerError)
+			return
+		} else {
+			wr.Write(p)
+		}
+	}
+}
+
+// --------------------- Service Layer -------------------------- //
+
+func (c *CommentTreeRequest) GetCommentTree(p *pgxpool.Pool, ctx context.Context) (*CommentTreeResponse, error) {
+	response := &CommentTreeResponse{}
+
+	if c.PostID == 0 {
+		return nil, errors.New("bad request body")
+	}
+
+	if err := response.FetchCommentTree(p, ctx, c.PostID, c.ParentID, c.Cursor, c.Limit, c.Replies, c.Depth, c.Sort); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// --------------------- Repository Layer -------------------------- //
+
+// Pages the top-level comments of a post (or the replies of parentID) and nests up to depth levels of replies
+// under them, at most replies per comment. Every level costs one query regardless of how many comments it holds.
+func (t *CommentTreeResponse) FetchCommentTree(p *pgxpool.Pool, ctx context.Context, postID, parentID, cursor, limit, replies, depth int, sort string) error {
+	var (
+		level      []*CommentNode
+		nextCursor int
+	)
+
+	viewerID, _ := UserFromContext(ctx)
+
+	if parentID == 0 {
+		rows, _ := p.Query(ctx, `
+			SELECT c.* FROM "Comment" c JOIN "Post" p ON p."id" = c."postId"
+			WHERE c."postId" = $1 AND c."depth" = 1 AND ($2 = 0 OR `+commentSorts[sort].after+`) AND `+commentVisible+` AND `+notBlocked(`c."authorId"`, "$4")+`
+			AND `+postVisible("p", "$4")+`
+			ORDER BY `+commentSorts[sort].order+`
+			LIMIT $3`, postID, cursor, limit+1, viewerID)
+
+		comments, err := pgx.CollectRows(rows, scanComment(p, ctx))
+		if err != nil {
+			return err
+		}
+
+		// The extra row only tells whether another page exists
+		if len(comments) > limit {
+			comments = comments[:limit]
+			nextCursor = comments[limit-1].ID
+		}
+
+		for _, comment := range comments {
+			level = append(level, newCommentNode(comment))
+		}
+	} else {
+		parent := &CommentNode{}
+
+		// Replies are only listed below a parent the viewer may see, so their own queries need no post check
+		err := p.QueryRow(ctx, `
+			SELECT c."id", c."path" FROM "Comment" c JOIN "Post" p ON p."id" = c."postId"
+			WHERE c."id" = $1 AND c."postId" = $2 AND `+notBlocked(`c."authorId"`, "$3")+` AND `+postVisible("p", "$3"),
+			parentID, postID, viewerID).Scan(&parent.ID, &parent.Path)
+		if err != nil {
+			return err
+		}
+
+		if err := fetchChildComments(p, ctx, postID, []*CommentNode{parent}, cursor, limit, sort); err != nil {
+			return err
+		}
+
+		level, nextCursor = parent.Replies, parent.NextCursor
+	}
+
+	result := level
+
+	for range depth {
+		parents := []*CommentNode{}
+		for _, node := range level {
+			if node.NumChild > 0 {
+				parents = append(parents, node)
+			}
+		}
+
+		if len(parents) == 0 {
+			break
+		}
+
+		if err := fetchChildComments(p, ctx, postID, parents, 0, replies, sort); err != nil {
+			return err
+		}
+
+		level = []*CommentNode{}
+		for _, parent := range parents {
+			level = append(level, parent.Replies...)
+		}
+	}
+
+	// Comments on the last level still tell clients their replies can be loaded
+	for _, node := range level {
+		if node.NumChild > 0 && len(node.Replies) == 0 {
+			node.MoreReplies = true
+		}
+	}
+
+	if result == nil {
+		result = []*CommentNode{}
+	}
+
+	t.Err = nil
+	t.Message = "Done!"
+	t.Result = result
+	t.NextCursor = nextCursor
+
+	return nil
+}
+
+// Attaches a page of direct replies (at most limit) to each of parents
+func fetchChildComments(p *pgxpool.Pool, ctx context.Context, postID int, parents []*CommentNode, cursor, limit int, sort string) error {
+	var (
+		ids    = []int{}
+		byPath = map[string]*CommentNode{}
+	)
+
+	for _, parent := range parents {
+		ids = append(ids, parent.ID)
+		byPath[parent.Path] = parent
+	}
+
+	viewerID, _ := UserFromContext(ctx)
+
+	// Columns are listed in table order so scanComment can read them
+	rows, _ := p.Query(ctx, `
+		SELECT c."id", c."path", c."depth", c."numchild", c."createdAt", c."updatedAt", c."message", c."postId", c."authorId", c."isDeleted"
+		FROM "Comment" pc
+		CROSS JOIN LATERAL (
+			SELECT c.*, row_number() OVER (ORDER BY `+commentSorts[sort].order+`) AS "rank"
+			FROM "Comment" c
+			WHERE c."postId" = pc."postId" AND c."depth" = pc."depth" + 1 AND c."path" LIKE pc."path" || '%' AND ($2 = 0 OR `+commentSorts[sort].after+`) AND `+commentVisible+` AND `+notBlocked(`c."authorId"`, "$5")+`
+			ORDER BY `+commentSorts[sort].order+`
+			LIMIT $3
+		) c
+		WHERE pc."id" = ANY($1) AND pc."postId" = $4
+		ORDER BY pc."id", c."rank"`, ids, cursor, limit+1, postID, viewerID)
+
+	comments, err := pgx.CollectRows(rows, scanComment(p, ctx))
+	if err != nil {
+		return err
+	}
+
+	for _, comment := range comments {
+		segments, err := customUtil.SplitPath(comment.Path)
+		if err != nil {
+			return err
+		}
+
+		parent, ok := byPath[comment.Path[:len(comment.Path)-len(segments[len(segments)-1])]]
+		if !ok {
+			continue
+		}
+
+		// The extra row only tells whether another page exists
+		if len(parent.Replies) == limit {
+			parent.MoreReplies = true
+			parent.NextCursor = parent.Replies[limit-1].ID
+			continue
+		}
+
+		parent.Replies = append(parent.Replies, newCommentNode(comment))
+	}
+
+	return nil
+}
+
+func (c *CommentTreeRequest) Parse(r *http.Request) error {
+	if userID, ok := UserFromContext(r.Context()); !ok || userID == 0 {
+		return errors.New("userID not found")
+	}
+
+	postID, err := strconv.ParseInt(r.PathValue("postID"), 10, 0)
+	if err != nil {
+		return err
+	}
+	c.PostID = int(postID)
+
+	if c.ParentID, err = parseIntQuery(r, "parent", 0); err != nil {
+		return err
+	}
+
+	if c.Cursor, err = parseIntQuery(r, "cursor", 0); err != nil {
+		return err
+	}
+
+	if c.Limit, err = parseLimit(r); err != nil {
+		return err
+	}
+
+	if c.Replies, err = parseIntQuery(r, "replies", customUtil.COMMENT_REPLY_PAGE_SIZE); err != nil {
+		return err
+	}
+
+	if c.Replies <= 0 {
+		return errors.New("replies must be positive")
+	}
+	c.Replies = min(c.Replies, customUtil.MAX_PAGE_SIZE)
+
+	if c.Depth, err = parseIntQuery(r, "depth", customUtil.COMMENT_TREE_DEPTH); err != nil {
+		return err
+	}
+
+	if c.Depth < 0 {
+		return errors.New("depth must not be negative")
+	}
+	c.Depth = min(c.Depth, customUtil.COMMENT_TREE_MAX_DEPTH)
+
+	c.Sort = customUtil.COMMENT_SORT_NEWEST
+	if sort := r.URL.Query().Get("sort"); sort != "" {
+		if _, ok := commentSorts[sort]; !ok {
+			return fmt.Errorf("unknown sort %q", sort)
+		}
+		c.Sort = sort
+	}
+
+	return nil
+}
+
+// Parses the flat listing: top-level comments only, with ?preview=<n> direct replies under each
+func (c *CommentTreeRequest) ParseList(r *http.Request) error {
+	if err := c.Parse(r); err != nil {
+		return err
+	}
+
+	preview, err := parseIntQuery(r, "preview", 0)
+	if err != nil {
+		return err
+	}
+
+	if preview < 0 {
+		return errors.New("preview must not be negative")
+	}
+
+	c.ParentID = 0
+	c.Depth = 0
+	c.Replies = min(preview, customUtil.MAX_PAGE_SIZE)
+
+	if c.Replies > 0 {
+		c.Depth = 1
+	}
+
+	return nil
+}
+
+// --------------------- Utility Layer -------------------------- //
+
+// Wraps comment in a node, hiding the message and author of soft-deleted comments
+func newCommentNode(comment *Comment) *CommentNode {
+	if comment.IsDeleted {
+		comment.Message = customUtil.DELETED_COMMENT_MESSAGE
+		comment.AuthorID = 0
+		comment.Author = Author{}
+		comment.Mentions = nil
+		comment.Reactions = nil
+		comment.MyReaction = 0
+	}
+
+	return &CommentNode{Comment: *comment}
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Schema changes shipped with the backend, applied in filename order
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Applies every file in migrations/ that is not yet recorded in "_Migration".
+// Each file runs in its own transaction together with its bookkeeping row.
+func Migrate(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS "_Migration" ("name" TEXT PRIMARY KEY, "appliedAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP)`)
+	if err != nil {
+		return err
+	}
+
+	// ReadDir returns entries sorted by filename
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := applyMigration(pool, ctx, entry.Name()); err != nil {
+			return fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(pool *pgxpool.Pool, ctx context.Context, name string) error {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadWrite, IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Nothing inserted means the file was applied before
+	res, err := tx.Exec(ctx, `INSERT INTO "_Migration" ("name") VALUES ($1) ON CONFLICT DO NOTHING`, name)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() == 0 {
+		return nil
+	}
+
+	sql, err := migrations.ReadFile("migrations/" + name)
+	if err != nil {
+		return err
+	}
+
+	// No arguments makes pgx use the simple protocol, which allows several statements per file
+	if _, err := tx.Exec(ctx, string(sql)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Applied migration %s\n", name)
+	return tx.Commit(ctx)
+}
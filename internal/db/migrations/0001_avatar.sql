@@ -0,0 +1,9 @@
+-- Resized copies of an uploaded profile picture, one row per size.
+-- "Profile"."profileUrl" keeps pointing at the default size (or an OAuth picture).
+CREATE TABLE IF NOT EXISTS "Avatar" (
+    "userId"    INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "size"      INTEGER NOT NULL,
+    "url"       TEXT NOT NULL,
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("userId", "size")
+);
@@ -0,0 +1,20 @@
+-- Hashtags parsed out of post messages
+CREATE TABLE IF NOT EXISTS "Tag" (
+    "id"        SERIAL PRIMARY KEY,
+    "name"      TEXT NOT NULL UNIQUE,
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Prefix lookups for autocomplete
+CREATE INDEX IF NOT EXISTS "Tag_name_prefix_idx" ON "Tag" ("name" text_pattern_ops);
+
+-- "createdAt" is when the tag was first attached to the post, used for trending windows
+CREATE TABLE IF NOT EXISTS "PostTag" (
+    "postId"    INTEGER NOT NULL REFERENCES "Post" ("id") ON DELETE CASCADE,
+    "tagId"     INTEGER NOT NULL REFERENCES "Tag" ("id") ON DELETE CASCADE,
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("postId", "tagId")
+);
+
+CREATE INDEX IF NOT EXISTS "PostTag_tagId_createdAt_idx" ON "PostTag" ("tagId", "createdAt");
+CREATE INDEX IF NOT EXISTS "PostTag_createdAt_idx" ON "PostTag" ("createdAt");
@@ -0,0 +1,42 @@
+-- Users who blocked another user. Mentions from a blocked author are not resolved.
+CREATE TABLE IF NOT EXISTS "UserBlock" (
+    "blockerId" INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "blockedId" INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("blockerId", "blockedId")
+);
+
+CREATE INDEX IF NOT EXISTS "UserBlock_blockedId_idx" ON "UserBlock" ("blockedId");
+
+-- Resolved @username mentions of a post or a comment
+CREATE TABLE IF NOT EXISTS "Mention" (
+    "id"        SERIAL PRIMARY KEY,
+    "postId"    INTEGER REFERENCES "Post" ("id") ON DELETE CASCADE,
+    "commentId" INTEGER REFERENCES "Comment" ("id") ON DELETE CASCADE,
+    "userId"    INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "authorId"  INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "offset"    INTEGER NOT NULL,
+    "length"    INTEGER NOT NULL,
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (("postId" IS NULL) <> ("commentId" IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS "Mention_postId_idx" ON "Mention" ("postId");
+CREATE INDEX IF NOT EXISTS "Mention_commentId_idx" ON "Mention" ("commentId");
+CREATE INDEX IF NOT EXISTS "Mention_userId_idx" ON "Mention" ("userId");
+
+CREATE INDEX IF NOT EXISTS "User_username_lower_idx" ON "User" (lower("username"));
+
+-- Activity addressed to "userId", caused by "actorId"
+CREATE TABLE IF NOT EXISTS "Notification" (
+    "id"        SERIAL PRIMARY KEY,
+    "userId"    INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "actorId"   INTEGER REFERENCES "User" ("id") ON DELETE CASCADE,
+    "type"      TEXT NOT NULL,
+    "postId"    INTEGER REFERENCES "Post" ("id") ON DELETE CASCADE,
+    "commentId" INTEGER REFERENCES "Comment" ("id") ON DELETE CASCADE,
+    "readAt"    TIMESTAMP(3),
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS "Notification_userId_createdAt_idx" ON "Notification" ("userId", "createdAt" DESC);
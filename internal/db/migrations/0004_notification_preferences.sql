@@ -0,0 +1,10 @@
+-- Opt-outs per notification type. A missing row means the type is enabled.
+CREATE TABLE IF NOT EXISTS "NotificationPreference" (
+    "userId"    INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "type"      TEXT NOT NULL,
+    "enabled"   BOOLEAN NOT NULL DEFAULT true,
+    "updatedAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("userId", "type")
+);
+
+CREATE INDEX IF NOT EXISTS "Notification_userId_unread_idx" ON "Notification" ("userId") WHERE "readAt" IS NULL;
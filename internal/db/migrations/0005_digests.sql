@@ -0,0 +1,9 @@
+-- Where digests are sent. Google logins fill it in, everyone else sets it in their settings.
+ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "email" TEXT;
+
+CREATE TABLE IF NOT EXISTS "UserSettings" (
+    "userId"          INTEGER PRIMARY KEY REFERENCES "User" ("id") ON DELETE CASCADE,
+    "digestFrequency" TEXT NOT NULL DEFAULT 'weekly' CHECK ("digestFrequency" IN ('off', 'daily', 'weekly')),
+    "lastDigestAt"    TIMESTAMP(3),
+    "updatedAt"       TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,36 @@
+-- Re-encodes comment paths from fixed 4-character base-36 segments ("0001")
+-- into length-prefixed segments ("11"), which have no upper bound on siblings.
+CREATE FUNCTION pg_temp."reencodePath"("old" TEXT) RETURNS TEXT AS $$
+DECLARE
+    abc CONSTANT TEXT := '0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ';
+    result TEXT := '';
+    digits TEXT;
+    n BIGINT;
+BEGIN
+    FOR i IN 0 .. length("old") / 4 - 1 LOOP
+        n := 0;
+        FOR j IN 1 .. 4 LOOP
+            n := n * 36 + strpos(abc, substr("old", i * 4 + j, 1)) - 1;
+        END LOOP;
+
+        digits := '';
+        LOOP
+            digits := substr(abc, (n % 36)::INTEGER + 1, 1) || digits;
+            n := n / 36;
+            EXIT WHEN n = 0;
+        END LOOP;
+
+        result := result || substr(abc, length(digits) + 1, 1) || digits;
+    END LOOP;
+
+    RETURN result;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+UPDATE "Comment" SET "path" = pg_temp."reencodePath"("path");
+
+-- Byte order keeps siblings in numeric order regardless of the database locale,
+-- and lets the index serve the prefix lookups of subtrees
+ALTER TABLE "Comment" ALTER COLUMN "path" TYPE TEXT COLLATE "C";
+
+CREATE INDEX IF NOT EXISTS "Comment_postId_path_idx" ON "Comment" ("postId", "path");
@@ -0,0 +1,11 @@
+-- Reactions left on comments, mirroring "Reactions" of posts. Ranks the "top" comment sort.
+CREATE TABLE IF NOT EXISTS "CommentReaction" (
+    "id"        SERIAL PRIMARY KEY,
+    "reactId"   INTEGER NOT NULL REFERENCES "Reacts" ("id") ON DELETE CASCADE,
+    "commentId" INTEGER NOT NULL REFERENCES "Comment" ("id") ON DELETE CASCADE,
+    "reactorId" INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS "CommentReaction_commentId_idx" ON "CommentReaction" ("commentId");
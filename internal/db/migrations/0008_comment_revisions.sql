@@ -0,0 +1,9 @@
+-- Previous messages of edited comments. "editedAt" is when the message was replaced.
+CREATE TABLE IF NOT EXISTS "CommentRevision" (
+    "id"        SERIAL PRIMARY KEY,
+    "commentId" INTEGER NOT NULL REFERENCES "Comment" ("id") ON DELETE CASCADE,
+    "message"   TEXT NOT NULL,
+    "editedAt"  TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS "CommentRevision_commentId_idx" ON "CommentRevision" ("commentId");
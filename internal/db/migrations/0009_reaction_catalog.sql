@@ -0,0 +1,14 @@
+-- Reaction types are listed to clients with an emoji and retired instead of deleted,
+-- so reactions already given keep their type
+ALTER TABLE "Reacts" ADD COLUMN IF NOT EXISTS "emoji" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "Reacts" ADD COLUMN IF NOT EXISTS "retiredAt" TIMESTAMP(3);
+
+UPDATE "Reacts" SET "emoji" = '👍' WHERE "name" = 'like' AND "emoji" = '';
+UPDATE "Reacts" SET "emoji" = '❤️' WHERE "name" = 'heart' AND "emoji" = '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS "Reacts_name_key" ON "Reacts" ("name");
+
+-- Admins manage the reaction catalog. The seeded "Admin" account is the first one.
+ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "isAdmin" BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE "User" SET "isAdmin" = true WHERE "username" = 'Admin';
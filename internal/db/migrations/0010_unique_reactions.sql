@@ -0,0 +1,9 @@
+-- One reaction per user per post or comment. The latest duplicate wins.
+DELETE FROM "Reactions" r USING "Reactions" newer
+WHERE r."reactorId" = newer."reactorId" AND r."postId" = newer."postId" AND r."id" < newer."id";
+
+DELETE FROM "CommentReaction" r USING "CommentReaction" newer
+WHERE r."reactorId" = newer."reactorId" AND r."commentId" = newer."commentId" AND r."id" < newer."id";
+
+CREATE UNIQUE INDEX IF NOT EXISTS "Reactions_reactorId_postId_key" ON "Reactions" ("reactorId", "postId");
+CREATE UNIQUE INDEX IF NOT EXISTS "CommentReaction_reactorId_commentId_key" ON "CommentReaction" ("reactorId", "commentId");
@@ -0,0 +1,3 @@
+-- Following a public account takes effect right away, following a private one goes through "FollowRequest".
+-- Posts of private accounts are only listed to their approved followers.
+ALTER TABLE "Profile" ADD COLUMN IF NOT EXISTS "isPrivate" BOOLEAN NOT NULL DEFAULT false;
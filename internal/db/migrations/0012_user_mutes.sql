@@ -0,0 +1,8 @@
+-- Users who muted another user. Unlike "UserBlock", muting is one-sided and only hides the muted
+-- user's posts and notifications from the muter.
+CREATE TABLE IF NOT EXISTS "UserMute" (
+    "muterId"   INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "mutedId"   INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("muterId", "mutedId")
+);
@@ -0,0 +1,8 @@
+-- Walking "UserNetwork" in either direction (suggestions, follower lists) without scanning the table
+CREATE INDEX IF NOT EXISTS "UserNetwork_followerId_assignedAt_idx" ON "UserNetwork" ("followerId", "assignedAt" DESC);
+CREATE INDEX IF NOT EXISTS "UserNetwork_followingId_assignedAt_idx" ON "UserNetwork" ("followingId", "assignedAt" DESC);
+
+CREATE INDEX IF NOT EXISTS "FollowRequest_targetId_idx" ON "FollowRequest" ("targetId");
+
+-- Shared categories and tags between users
+CREATE INDEX IF NOT EXISTS "Post_authorId_idx" ON "Post" ("authorId");
@@ -0,0 +1,21 @@
+-- Public @handles, stored lowercased. Separate from "User"."username", which is a login name and is
+-- missing for OAuth users.
+ALTER TABLE "Profile" ADD COLUMN IF NOT EXISTS "handle" TEXT;
+
+-- Existing usernames that are valid handles and unique regardless of case become the first handles
+UPDATE "Profile" pf SET "handle" = lower(u."username")
+FROM "User" u
+WHERE u."id" = pf."userId" AND pf."handle" IS NULL
+AND u."username" ~ '^[A-Za-z0-9_]{3,30}$'
+AND NOT EXISTS (SELECT 1 FROM "User" o WHERE o."id" <> u."id" AND lower(o."username") = lower(u."username"));
+
+CREATE UNIQUE INDEX IF NOT EXISTS "Profile_handle_key" ON "Profile" ("handle");
+
+-- Handles given up by a rename keep redirecting to their last owner and cannot be taken by anyone else
+CREATE TABLE IF NOT EXISTS "ProfileHandleHistory" (
+    "handle"    TEXT PRIMARY KEY,
+    "userId"    INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "changedAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS "ProfileHandleHistory_userId_idx" ON "ProfileHandleHistory" ("userId");
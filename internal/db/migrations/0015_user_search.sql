@@ -0,0 +1,9 @@
+-- Fuzzy user search over profiles. The indexed expressions must stay identical to
+-- profileNameText and profileAboutText in controllers/search.go for the planner to use them.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS "Profile_name_trgm_idx" ON "Profile"
+    USING GIN (lower(COALESCE("firstName", '') || ' ' || COALESCE("lastName", '') || ' ' || COALESCE("handle", '')) gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS "Profile_about_trgm_idx" ON "Profile"
+    USING GIN (lower(COALESCE("title", '') || ' ' || COALESCE("bio", '')) gin_trgm_ops);
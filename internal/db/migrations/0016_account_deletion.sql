@@ -0,0 +1,6 @@
+-- Self-service account deletion. "deleteAfter" is set when a user asks for deletion and cleared when they
+-- log in again before it passes; "deletedAt" is set once the account has been anonymized.
+ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "deleteAfter" TIMESTAMP(3);
+ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "deletedAt" TIMESTAMP(3);
+
+CREATE INDEX IF NOT EXISTS "User_deleteAfter_idx" ON "User" ("deleteAfter") WHERE "deleteAfter" IS NOT NULL AND "deletedAt" IS NULL;
@@ -0,0 +1,17 @@
+-- Personal data exports. Archives are written to EXPORT_DIR, outside the public media directory, and
+-- only served through signed links until "expiresAt".
+CREATE TABLE IF NOT EXISTS "DataExport" (
+    "id"          SERIAL PRIMARY KEY,
+    "userId"      INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "status"      TEXT NOT NULL DEFAULT 'pending' CHECK ("status" IN ('pending', 'running', 'ready', 'failed')),
+    "fileName"    TEXT,
+    "createdAt"   TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "startedAt"   TIMESTAMP(3),
+    "completedAt" TIMESTAMP(3),
+    "expiresAt"   TIMESTAMP(3)
+);
+
+CREATE INDEX IF NOT EXISTS "DataExport_userId_idx" ON "DataExport" ("userId", "createdAt" DESC);
+
+-- At most one export per user is waiting or being built
+CREATE UNIQUE INDEX IF NOT EXISTS "DataExport_userId_active_key" ON "DataExport" ("userId") WHERE "status" IN ('pending', 'running');
@@ -0,0 +1,3 @@
+-- Preferences without a column of their own (locale, timezone, theme, default post visibility), keyed by
+-- setting name. Only values a user chose are stored; missing keys fall back to the defaults in the app.
+ALTER TABLE "UserSettings" ADD COLUMN IF NOT EXISTS "preferences" JSONB NOT NULL DEFAULT '{}'::jsonb;
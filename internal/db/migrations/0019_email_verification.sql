@@ -0,0 +1,3 @@
+-- Digests are only mailed to confirmed addresses. Addresses set before this column existed were never
+-- confirmed, so their owners confirm them again from their digest settings.
+ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "emailVerifiedAt" TIMESTAMP(3);
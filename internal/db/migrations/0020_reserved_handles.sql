@@ -0,0 +1,9 @@
+-- Handles backfilled by 0014_profile_handles.sql that are reserved or contain blocked words. Their owners
+-- pick a new handle, as users without a username do. The lists are copies of ReservedHandles and
+-- BlockedHandleWords of utils/const.go and must be kept in sync with them.
+UPDATE "Profile" SET "handle" = NULL
+WHERE "handle" IN (
+    'admin', 'administrator', 'api', 'auth', 'help', 'login', 'logout', 'me', 'media', 'moderator',
+    'profiles', 'root', 'settings', 'signup', 'staff', 'support', 'system', 'unsubscribe', 'users'
+)
+OR replace("handle", '_', '') ~ 'asshole|bitch|cunt|faggot|fuck|nigga|nigger|rapist|retard|shit|slut|whore';
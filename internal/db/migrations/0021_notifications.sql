@@ -0,0 +1,15 @@
+-- Activity addressed to "userId", caused by "actorId". Databases migrated before this file got the table
+-- from 0003_mentions.sql, which created it ahead of the notifications feature.
+CREATE TABLE IF NOT EXISTS "Notification" (
+    "id"        SERIAL PRIMARY KEY,
+    "userId"    INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "actorId"   INTEGER REFERENCES "User" ("id") ON DELETE CASCADE,
+    "type"      TEXT NOT NULL,
+    "postId"    INTEGER REFERENCES "Post" ("id") ON DELETE CASCADE,
+    "commentId" INTEGER REFERENCES "Comment" ("id") ON DELETE CASCADE,
+    "readAt"    TIMESTAMP(3),
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS "Notification_userId_createdAt_idx" ON "Notification" ("userId", "createdAt" DESC);
+CREATE INDEX IF NOT EXISTS "Notification_userId_unread_idx" ON "Notification" ("userId") WHERE "readAt" IS NULL;
@@ -0,0 +1,10 @@
+-- Users who blocked another user. A block hides both users from each other. Databases migrated before
+-- this file got the table from 0003_mentions.sql, which created it ahead of the blocks feature.
+CREATE TABLE IF NOT EXISTS "UserBlock" (
+    "blockerId" INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "blockedId" INTEGER NOT NULL REFERENCES "User" ("id") ON DELETE CASCADE,
+    "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("blockerId", "blockedId")
+);
+
+CREATE INDEX IF NOT EXISTS "UserBlock_blockedId_idx" ON "UserBlock" ("blockedId");
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Writes every message as an .eml file, for development
+type FileMailer struct {
+	From string
+	Dir  string
+}
+
+func NewFileMailer(from string) (*FileMailer, error) {
+	dir, ok := os.LookupEnv("MAIL_DIR")
+	if !ok {
+		return nil, errors.New("environment variable not found")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{From: from, Dir: dir}, nil
+}
+
+func (f *FileMailer) Send(ctx context.Context, m *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	msg, err := m.Bytes(f.From)
+	if err != nil {
+		return err
+	}
+
+	// Keep the recipient in the name so files are easy to find
+	recipient := strings.NewReplacer("/", "_", "@", "_at_").Replace(m.To)
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), recipient)
+
+	return os.WriteFile(filepath.Join(f.Dir, name), msg, 0o644)
+}
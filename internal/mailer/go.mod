@@ -0,0 +1,3 @@
+module github.com/app-clone-tod-mailer
+
+go 1.25.1
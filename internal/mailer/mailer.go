@@ -0,0 +1,133 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"slices"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // extra headers, e.g. List-Unsubscribe
+}
+
+// Anything that can deliver a Message
+type Mailer interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// Picks a Mailer from the MAILER environment variable ("smtp" or "file")
+func FromEnv() (Mailer, error) {
+	from, ok := os.LookupEnv("MAIL_FROM")
+	if !ok {
+		return nil, errors.New("environment variable not found")
+	}
+
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		return NewSMTPMailer(from)
+	case "file", "":
+		return NewFileMailer(from)
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", os.Getenv("MAILER"))
+	}
+}
+
+// Renders m as a multipart/alternative MIME message with a quoted-printable text and HTML part.
+// Headers are written in a fixed order, the extra ones sorted after the standard ones.
+func (m *Message) Bytes(from string) ([]byte, error) {
+	var (
+		buf  bytes.Buffer
+		body bytes.Buffer
+	)
+
+	writer := multipart.NewWriter(&body)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Keeps lines under the 998 characters SMTP allows, whatever the length of the content
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	messageID, err := newMessageID()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := [][2]string{
+		{"From", from},
+		{"To", m.To},
+		{"Subject", mime.QEncoding.Encode("UTF-8", m.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+
+	// An extra header replaces a standard one of the same name in place
+	for _, key := range slices.Sorted(maps.Keys(m.Headers)) {
+		i := slices.IndexFunc(headers, func(header [2]string) bool { return header[0] == key })
+		if i < 0 {
+			headers = append(headers, [2]string{key, m.Headers[key]})
+		} else {
+			headers[i][1] = m.Headers[key]
+		}
+	}
+
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+	}
+
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+func newMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("<%s@app-clone-tod>", hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"os"
+)
+
+// Delivers mail through an SMTP relay
+type SMTPMailer struct {
+	From     string
+	Addr     string // host:port
+	Username string
+	Password string
+}
+
+func NewSMTPMailer(from string) (*SMTPMailer, error) {
+	err := errors.New("missing environment variables")
+
+	host, ok := os.LookupEnv("SMTP_HOST")
+	if !ok {
+		return nil, err
+	}
+
+	port, ok := os.LookupEnv("SMTP_PORT")
+	if !ok {
+		return nil, err
+	}
+
+	// Credentials are optional for local relays
+	return &SMTPMailer{
+		From:     from,
+		Addr:     net.JoinHostPort(host, port),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}, nil
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, m *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	msg, err := m.Bytes(s.From)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	return smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, msg)
+}
@@ -32,7 +32,8 @@ type Category struct {
 }
 
 type Reacts struct {
-	name string
+	name  string
+	emoji string
 }
 
 func main() {
@@ -93,17 +94,17 @@ func main() {
 		fmt.Println("Admin Profile created")
 	}
 
-	like := &Reacts{name: "like"}
-	heart := &Reacts{name: "heart"}
+	like := &Reacts{name: "like", emoji: "👍"}
+	heart := &Reacts{name: "heart", emoji: "❤️"}
 
-	_, err = tx.Exec(ctx, `INSERT INTO "Reacts" ("id", "name") VALUES ($1, $2)`, 1, like.name)
+	_, err = tx.Exec(ctx, `INSERT INTO "Reacts" ("id", "name", "emoji") VALUES ($1, $2, $3)`, 1, like.name, like.emoji)
 	fmt.Println("Like react created")
 	if err != nil {
 		fmt.Println(err.Error())
 		return
 	}
 
-	_, err = tx.Exec(ctx, `INSERT INTO "Reacts" ("id", "name") VALUES ($1, $2)`, 2, heart.name)
+	_, err = tx.Exec(ctx, `INSERT INTO "Reacts" ("id", "name", "emoji") VALUES ($1, $2, $3)`, 2, heart.name, heart.emoji)
 	fmt.Println("Heart react created")
 	if err != nil {
 		fmt.Println(err.Error())
@@ -134,7 +135,7 @@ func (u *User) SignAdminUser(tx pgx.Tx) error {
 		return err
 	}
 
-	_, err = tx.Exec(context.Background(), `INSERT INTO "User" ("username", "password") VALUES ($1, $2) RETURNING "id"`, u.username, pw)
+	_, err = tx.Exec(context.Background(), `INSERT INTO "User" ("username", "password", "isAdmin") VALUES ($1, $2, true) RETURNING "id"`, u.username, pw)
 	if err != nil {
 		return err
 	}
@@ -8,13 +8,14 @@ require (
 	github.com/app-clone-tod-auth v0.0.0-00010101000000-000000000000
 	github.com/app-clone-tod-controllers v0.0.0-00010101000000-000000000000
 	github.com/app-clone-tod-db v0.0.0-00010101000000-000000000000
+	github.com/app-clone-tod-mailer v0.0.0-00010101000000-000000000000
+	github.com/app-clone-tod-utils v0.0.0-00010101000000-000000000000
 	github.com/jackc/pgx/v5 v5.7.6
 	github.com/joho/godotenv v1.5.1
 )
 
 require (
 	cloud.google.com/go/compute/metadata v0.3.0 // indirect
-	github.com/app-clone-tod-utils v0.0.0-00010101000000-000000000000 // indirect
 	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
@@ -30,3 +31,5 @@ replace github.com/app-clone-tod-controllers => ../controllers/
 replace github.com/app-clone-tod-utils => ../utils/
 
 replace github.com/app-clone-tod-db => ../db/
+
+replace github.com/app-clone-tod-mailer => ../mailer/
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
@@ -10,6 +11,8 @@ import (
 	auth "github.com/app-clone-tod-auth"
 	controllers "github.com/app-clone-tod-controllers"
 	db "github.com/app-clone-tod-db"
+	mailer "github.com/app-clone-tod-mailer"
+	customUtil "github.com/app-clone-tod-utils"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/joho/godotenv"
@@ -34,6 +37,11 @@ func init() {
 		log.Fatal(err.Error())
 	}
 
+	// Bring the schema up to date before serving any request
+	if err = db.Migrate(dbPool); err != nil {
+		log.Fatal(err.Error())
+	}
+
 }
 
 func main() {
@@ -58,10 +66,41 @@ func main() {
 	base := controllers.BaseChain
 	// involves getting userID
 	protected := controllers.PrivateChain
+	// involves getting userID when the client is logged in
+	public := controllers.PublicChain
+	// involves checking the user is an admin
+	admin := controllers.AdminChain(dbPool)
 
 	auth := &auth.AuthHandler{}
 	ctr := &controllers.Controller{}
 
+	mediaDir, err := controllers.MediaDir()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// Export archives are written outside of mediaDir, which is served publicly
+	if _, err := controllers.ExportDir(); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// Digests go out through smtp or local files depending on env
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	go controllers.RunDigests(context.Background(), dbPool, mail)
+	go controllers.RunDataExports(context.Background(), dbPool, mail)
+
+	// Deleted accounts are anonymized once their grace period is over
+	retention, err := controllers.RetentionRulesFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	go controllers.RunAccountPurges(context.Background(), dbPool, retention)
+
 	http.Handle("POST "+*host+"/logout/{$}", base.Handle(auth.Logout))
 	http.Handle("POST "+*host+"/signup/{$}", base.Handle(auth.Signup(dbPool)))
 	http.Handle("POST "+*host+"/auth/local/{$}", base.Handle(auth.AuthLocal(dbPool)))
@@ -72,15 +111,52 @@ func main() {
 	http.Handle("GET "+*host+"/auth/google/callback/{$}", base.Handle(auth.AuthGoogleCallback(dbPool)))
 
 	http.Handle("GET "+*host+"/users/auth/me/{$}", base.Handle(auth.AuthMe))
+	http.Handle(*host+"/unsubscribe/{$}", base.Handle(ctr.Unsubscribe(dbPool)))
+	http.Handle("GET "+*host+"/verify-email/{$}", base.Handle(ctr.VerifyEmail(dbPool)))
+	http.Handle(*host+"/users/account/{$}", protected.Handle(ctr.Account(dbPool)))
+	http.Handle(*host+"/users/account/export/{$}", protected.Handle(ctr.Export(dbPool)))
+	http.Handle("GET "+*host+"/exports/{$}", base.Handle(ctr.DownloadExport(dbPool)))
 	http.Handle(*host+"/users/profile/", protected.Handle(ctr.Profile(dbPool)))
+	http.Handle(*host+"/users/profile/avatar/{$}", protected.Handle(ctr.Avatar(dbPool)))
+	http.Handle("PUT "+*host+"/users/profile/handle/{$}", protected.Handle(ctr.ProfileHandle(dbPool)))
+	http.Handle("GET "+*host+customUtil.PROFILE_ROUTE+"{handle}", public.Handle(ctr.PublicProfile(dbPool)))
 	http.Handle(*host+"/users/request/", protected.Handle(ctr.Request(dbPool)))
+	http.Handle("POST "+*host+"/users/request/{requesterID}/accept/{$}", protected.Handle(ctr.AcceptRequest(dbPool)))
+	http.Handle("POST "+*host+"/users/request/{requesterID}/decline/{$}", protected.Handle(ctr.DeclineRequest(dbPool)))
 	http.Handle(*host+"/users/network/", protected.Handle(ctr.Network(dbPool)))
+	http.Handle("GET "+*host+"/users/network/suggestions/{$}", protected.Handle(ctr.Suggestions(dbPool)))
+	http.Handle("GET "+*host+"/users/search/{$}", protected.Handle(ctr.UserSearch(dbPool)))
+	http.Handle("GET "+*host+"/users/network/relationship/{$}", protected.Handle(ctr.Relationships(dbPool)))
+	http.Handle("GET "+*host+"/users/network/{userID}/mutuals/{$}", protected.Handle(ctr.MutualFollowers(dbPool)))
+	http.Handle("GET "+*host+"/users/block/{$}", protected.Handle(ctr.Block(dbPool)))
+	http.Handle(*host+"/users/block/{userID}/{$}", protected.Handle(ctr.Block(dbPool)))
+	http.Handle("GET "+*host+"/users/mute/{$}", protected.Handle(ctr.Mute(dbPool)))
+	http.Handle(*host+"/users/mute/{userID}/{$}", protected.Handle(ctr.Mute(dbPool)))
 	http.Handle(*host+"/users/reaction/", protected.Handle(ctr.Reaction(dbPool)))
+	http.Handle("GET "+*host+"/users/reaction/reactors/{$}", protected.Handle(ctr.Reactors(dbPool)))
+	http.Handle("GET "+*host+"/users/reaction/type/{$}", protected.Handle(ctr.ReactionType(dbPool)))
+	http.Handle("POST "+*host+"/users/reaction/type/{$}", admin.Handle(ctr.ReactionType(dbPool)))
+	http.Handle("DELETE "+*host+"/users/reaction/type/{reactID}", admin.Handle(ctr.ReactionType(dbPool)))
 	http.Handle("GET "+*host+"/users/chat/{chatID}", protected.Handle(ctr.Chat(dbPool)))
+	http.Handle(*host+"/users/notification/{$}", protected.Handle(ctr.Notification(dbPool)))
+	http.Handle("GET "+*host+"/users/notification/count/{$}", protected.Handle(ctr.NotificationCount(dbPool)))
+	http.Handle(*host+"/users/notification/preference/{$}", protected.Handle(ctr.NotificationPreference(dbPool)))
+	http.Handle(*host+"/users/settings/{$}", protected.Handle(ctr.Settings(dbPool)))
+	http.Handle(*host+"/users/settings/digest/{$}", protected.Handle(ctr.DigestSettings(dbPool, mail)))
 
 	http.Handle(*host+"/users/post/{$}", protected.Handle(ctr.BasePostRoute(dbPool)))
 	http.Handle(*host+"/users/post/{postID}", protected.Handle(ctr.DynamicPostRoute(dbPool)))
 	http.Handle(*host+"/users/post/{postID}/comment/{commentID}", protected.Handle(ctr.Comment(dbPool)))
+	http.Handle("GET "+*host+"/users/post/{postID}/comment/{commentID}/revisions/{$}", protected.Handle(ctr.CommentRevisions(dbPool)))
+	http.Handle("GET "+*host+"/users/post/{postID}/comments/{$}", protected.Handle(ctr.PostComments(dbPool)))
+	http.Handle("GET "+*host+"/users/post/{postID}/comments/tree/{$}", protected.Handle(ctr.CommentTree(dbPool)))
+
+	http.Handle("GET "+*host+"/users/tag/{$}", protected.Handle(ctr.Tag(dbPool)))
+	http.Handle("GET "+*host+"/users/tag/trending/{$}", protected.Handle(ctr.TrendingTags(dbPool)))
+	http.Handle("GET "+*host+"/users/tag/{tag}/posts/{$}", protected.Handle(ctr.TagPosts(dbPool)))
+
+	// Uploaded files such as avatars
+	http.Handle("GET "+*host+customUtil.MEDIA_ROUTE, http.StripPrefix(customUtil.MEDIA_ROUTE, http.FileServer(http.Dir(mediaDir))))
 
 	fmt.Printf("\nServer listening on http://%s:%s\n", *host, *port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", *port), nil))
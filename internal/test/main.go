@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	customUtil "github.com/app-clone-tod-utils"
@@ -69,6 +70,7 @@ func main() {
 		// {URL: "/users/post/e", Handler: Ctr.GetHandler(Ctr.HandleSinglePost(dbConfig))},
 		// {URL: "/users/post/5/comment/3", Handler: testCommentController},
 		// {URL: "/users/post/2/comment/e", Handler: Ctr.GetHandler(Ctr.HandleSingleComment(dbConfig))},
+		// {URL: "/users/post/5/comment/3", Handler: testConcurrentReplies},
 	}
 
 	for _, t := range test {
@@ -144,6 +146,95 @@ func testCommentController(m, requestURL string) (*http.Cookie, error) {
 	return nil, nil
 }
 
+// Fires concurrent replies at one comment, then checks every reply got its own path
+func testConcurrentReplies(m, requestURL string) (*http.Cookie, error) {
+	const REPLIES = 50
+
+	var (
+		C  = &Color{}
+		wg sync.WaitGroup
+	)
+
+	type Comment struct {
+		ID   int    `json:"id,omitzero"`
+		Path string `json:"path,omitzero"`
+	}
+
+	type Response struct {
+		Result []*Comment `json:"result,omitzero"`
+	}
+
+	cookie, err := testLocalAuth(http.MethodPost, "/auth/local/")
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(chan int, REPLIES)
+	for i := range REPLIES {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			b, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Reply %d", i)})
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", "http://localhost:8080", requestURL), bytes.NewReader(b))
+			req.AddCookie(cookie)
+			req.Header.Set("Content-type", "application/json")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+
+	wg.Wait()
+	close(statuses)
+
+	failed := 0
+	for status := range statuses {
+		if status < 200 || status >= 300 {
+			failed++
+		}
+	}
+
+	// Read back the whole subtree of the comment
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s%s?getReplies=true", "http://localhost:8080", requestURL), nil)
+	req.AddCookie(cookie)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response := &Response{}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, err
+	}
+
+	paths := map[string]int{}
+	duplicates := 0
+	for _, comment := range response.Result {
+		if _, ok := paths[comment.Path]; ok {
+			duplicates++
+		}
+		paths[comment.Path] = comment.ID
+	}
+
+	message := fmt.Sprintf("URL: %s,\n Replies: %d,\n Failed: %d,\n Comments read: %d,\n Duplicate paths: %d\n", requestURL, REPLIES, failed, len(response.Result), duplicates)
+
+	if failed == 0 && duplicates == 0 {
+		fmt.Println(C.Green(message))
+	} else {
+		fmt.Println(C.Red(message))
+	}
+
+	return nil, nil
+}
+
 func testPostController(m, requestURL string) (*http.Cookie, error) {
 	var (
 		C   = &Color{}
@@ -288,16 +379,14 @@ func testReactionController(m, url string) (*http.Cookie, error) {
 	C := &Color{}
 
 	type Body struct {
-		Id_react  int `json:"id_react,omitzero"` // the row id
 		PostID    int `json:"postID,omitzero"`
 		ReactorID int `json:"reactorID,omitzero"`
 		ReactID   int `json:"reactID,omitzero"` // lists what type of react from "React" table
 	}
 
 	params := Body{
-		Id_react: 1,
-		PostID:   1,
-		ReactID:  1,
+		PostID:  1,
+		ReactID: 1,
 	}
 
 	b, err := json.Marshal(params)
@@ -307,7 +396,7 @@ func testReactionController(m, url string) (*http.Cookie, error) {
 
 	reader := bytes.NewReader(b)
 
-	req, _ := http.NewRequest(m, fmt.Sprintf("%s%s%s", "http://localhost:8080", url, "?id_react=1"), reader)
+	req, _ := http.NewRequest(m, fmt.Sprintf("%s%s%s", "http://localhost:8080", url, "?postID=1"), reader)
 
 	cookie, err := testLocalAuth(http.MethodPost, "/auth/local/")
 	if err != nil {
@@ -1,26 +1,162 @@
 package utils
 
-import (
-	"strings"
-	"time"
-)
+import "time"
 
 const (
-	STEP_LENGTH              = 4
-	ABC                      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	HASH_COST                = 10
-	COOKIE_NAME              = "token"
-	GITHUB_OAUTH_COOKIE_NAME = "github_cookie"
-	GOOGLE_OAUTH_COOKIE_NAME = "google_cookie"
-	GITHUB_USER_ENDPOINT     = "https://api.github.com/user"
-	GOOGLE_USER_ENDPOINT     = "https://www.googleapis.com/oauth2/v3/userinfo"
-	GITHUB_REDIRECT_URI      = "/auth/github/callback/"
-	GOOGLE_REDIRECT_URI      = "/auth/google/callback/"
-	HTTP_TIMEOUT             = time.Second * 5
+	ABC                         = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	HASH_COST                   = 10
+	COOKIE_NAME                 = "token"
+	GITHUB_OAUTH_COOKIE_NAME    = "github_cookie"
+	GOOGLE_OAUTH_COOKIE_NAME    = "google_cookie"
+	GITHUB_USER_ENDPOINT        = "https://api.github.com/user"
+	GOOGLE_USER_ENDPOINT        = "https://www.googleapis.com/oauth2/v3/userinfo"
+	GITHUB_REDIRECT_URI         = "/auth/github/callback/"
+	GOOGLE_REDIRECT_URI         = "/auth/google/callback/"
+	HTTP_TIMEOUT                = time.Second * 5
+	MEDIA_ROUTE                 = "/media/"
+	AVATAR_MAX_BYTES            = 5 << 20
+	AVATAR_MULTIPART_OVERHEAD   = 64 << 10 // room for the multipart boundaries and part headers around the file
+	AVATAR_DEFAULT_SIZE         = 128
+	AVATAR_MAX_DIMENSION        = 4096 // widest or tallest upload accepted, checked before decoding
+	DEFAULT_PAGE_SIZE           = 20
+	MAX_PAGE_SIZE               = 100
+	TRENDING_WINDOW             = time.Hour * 24
+	NOTIFICATION_MENTION        = "mention"
+	NOTIFICATION_FOLLOW         = "follow"
+	NOTIFICATION_FOLLOW_REQUEST = "follow_request"
+	NOTIFICATION_REACTION       = "reaction"
+	NOTIFICATION_COMMENT        = "comment"
+	NOTIFICATION_REPLY          = "reply"
+	NOTIFICATION_FOLLOW_ACCEPT  = "follow_accept"
+	NOTIFICATION_FOLLOW_DECLINE = "follow_decline"
+	NOTIFICATION_GROUP_ACTORS   = 3 // actors whose details are sent with a notification group
+	DIGEST_OFF                  = "off"
+	DIGEST_DAILY                = "daily"
+	DIGEST_WEEKLY               = "weekly"
+	DIGEST_DEFAULT_FREQUENCY    = DIGEST_WEEKLY
+	DIGEST_INTERVAL             = time.Hour      // how often the digest job looks for users due a digest
+	EMAIL_VERIFICATION_TTL      = time.Hour * 24 // how long the link confirming a new digest address works
+	DIGEST_ITEMS                = 5              // entries per digest section
+	COMMENT_SORT_NEWEST         = "newest"
+	COMMENT_SORT_OLDEST         = "oldest"
+	COMMENT_SORT_TOP            = "top" // most reacted first
+	COMMENT_TREE_DEPTH          = 3     // reply levels nested under a top-level comment by default
+	COMMENT_TREE_MAX_DEPTH      = 10
+	COMMENT_REPLY_PAGE_SIZE     = 5                // replies sent per comment before a "load more" cursor
+	DELETED_COMMENT_MESSAGE     = "[deleted]"      // shown in place of soft-deleted comments that still have replies
+	COMMENT_EDIT_WINDOW         = time.Minute * 15 // overridable with the COMMENT_EDIT_WINDOW env variable
+	FOLLOW_STATE_NONE           = "none"
+	FOLLOW_STATE_FOLLOWING      = "following"
+	FOLLOW_STATE_REQUESTED      = "requested" // a follow request is pending
+	FOLLOW_STATE_SELF           = "self"
+	SUGGESTION_GRAPH_SAMPLE     = 500 // latest follows of the caller whose own follows are considered for suggestions
+	SUGGESTION_CANDIDATES       = 200 // friends-of-friends with the most mutuals that are then ranked on shared topics
+	SUGGESTION_MUTUAL_WEIGHT    = 3   // a mutual connection weighs as much as this many shared categories or tags
+	HANDLE_MIN_LENGTH           = 3
+	HANDLE_MAX_LENGTH           = 30
+	PROFILE_ROUTE               = "/profiles/" // public profiles are served at PROFILE_ROUTE + handle
+	SEARCH_MIN_QUERY_LENGTH     = 2
+	SEARCH_NETWORK_BOOST        = 0.25                // added to a match's score per doubling of the people the caller follows who follow them
+	ACCOUNT_DELETION_GRACE      = time.Hour * 24 * 30 // overridable with the ACCOUNT_DELETION_GRACE env variable
+	ACCOUNT_PURGE_INTERVAL      = time.Hour           // how often the purge job looks for accounts past their grace period
+	DELETED_USER_FIRST_NAME     = "Deleted"
+	DELETED_USER_LAST_NAME      = "user"
+	RETENTION_DELETE            = "delete"
+	RETENTION_TOMBSTONE         = "tombstone" // content stays in place, blanked or attributed to the anonymized account
+	RETENTION_POSTS             = "posts"
+	RETENTION_COMMENTS          = "comments"
+	RETENTION_REACTIONS         = "reactions"
+	RETENTION_MESSAGES          = "messages"
+	RETENTION_NETWORK           = "network"
+	EXPORT_PENDING              = "pending"
+	EXPORT_RUNNING              = "running"
+	EXPORT_READY                = "ready"
+	EXPORT_FAILED               = "failed"
+	EXPORT_INTERVAL             = time.Minute        // how often the export job looks for requested exports
+	EXPORT_STALE_AFTER          = time.Minute * 30   // a running export not done by then is assumed lost and built again
+	EXPORT_LINK_TTL             = time.Hour * 24 * 7 // how long a finished archive can be downloaded before it is removed
+	NOTIFICATION_EXPORT_READY   = "export_ready"
+	SETTING_LOCALE              = "locale"
+	SETTING_TIMEZONE            = "timezone"
+	SETTING_THEME               = "theme"
+	SETTING_POST_VISIBILITY     = "defaultPostVisibility"
+	SETTING_PRIVACY             = "privacy"
+	SETTING_NOTIFICATIONS       = "notifications"
+	SETTING_DIGEST_FREQUENCY    = "digestFrequency"
+	THEME_LIGHT                 = "light"
+	THEME_DARK                  = "dark"
+	THEME_SYSTEM                = "system" // follows the device
+	POST_VISIBILITY_PUBLISHED   = "published"
+	POST_VISIBILITY_DRAFT       = "draft"
+	PRIVACY_PUBLIC              = "public"
+	PRIVACY_PRIVATE             = "private" // follow requests need approval, see "Profile"."isPrivate"
 )
 
-// Repeat "0" 4 times
-var MinSegment = strings.Repeat(string(ABC[0]), STEP_LENGTH)
+// Reaction on a comment, kept apart from NOTIFICATION_REACTION so it groups per comment
+const NOTIFICATION_COMMENT_REACTION = "comment_reaction"
+
+// Square sizes (in pixels) an uploaded avatar is resized into
+var AvatarSizes = []int{48, AVATAR_DEFAULT_SIZE, 256}
+
+// Every notification type users can receive (and opt out of)
+var NotificationTypes = []string{
+	NOTIFICATION_MENTION,
+	NOTIFICATION_FOLLOW,
+	NOTIFICATION_FOLLOW_REQUEST,
+	NOTIFICATION_REACTION,
+	NOTIFICATION_COMMENT_REACTION,
+	NOTIFICATION_COMMENT,
+	NOTIFICATION_REPLY,
+	NOTIFICATION_FOLLOW_ACCEPT,
+	NOTIFICATION_FOLLOW_DECLINE,
+	NOTIFICATION_EXPORT_READY,
+}
+
+// Handles nobody can take because they collide with routes or could pass as staff.
+// db/migrations/0020_reserved_handles.sql holds an SQL copy that must be kept in sync with this list.
+var ReservedHandles = []string{
+	"admin", "administrator", "api", "auth", "help", "login", "logout", "me", "media", "moderator",
+	"profiles", "root", "settings", "signup", "staff", "support", "system", "unsubscribe", "users",
+}
+
+// Handles containing any of these (ignoring underscores and case) are refused.
+// db/migrations/0020_reserved_handles.sql holds an SQL copy that must be kept in sync with this list.
+var BlockedHandleWords = []string{
+	"asshole", "bitch", "cunt", "faggot", "fuck", "nigga", "nigger", "rapist", "retard", "shit", "slut", "whore",
+}
+
+// Notification types where repeated events on the same target collapse into one entry
+var GroupedNotificationTypes = []string{
+	NOTIFICATION_FOLLOW,
+	NOTIFICATION_REACTION,
+	NOTIFICATION_COMMENT_REACTION,
+	NOTIFICATION_COMMENT,
+	NOTIFICATION_REPLY,
+}
+
+// What happens to each kind of content of a deleted account, overridable with RETENTION_<KIND> env
+// variables (e.g. RETENTION_POSTS=tombstone)
+var RetentionDefaults = map[string]string{
+	RETENTION_POSTS:     RETENTION_DELETE,
+	RETENTION_COMMENTS:  RETENTION_TOMBSTONE, // keeps reply threads readable
+	RETENTION_REACTIONS: RETENTION_DELETE,
+	RETENTION_MESSAGES:  RETENTION_TOMBSTONE, // keeps conversations readable
+	RETENTION_NETWORK:   RETENTION_DELETE,
+}
+
+// Default of each preference stored in "UserSettings"."preferences"
+var PreferenceDefaults = map[string]string{
+	SETTING_LOCALE:          "en",
+	SETTING_TIMEZONE:        "UTC",
+	SETTING_THEME:           THEME_SYSTEM,
+	SETTING_POST_VISIBILITY: POST_VISIBILITY_DRAFT,
+}
+
+// Locales the frontend has translations for
+var SupportedLocales = []string{"de", "en", "en-GB", "es", "fr", "it", "ja", "pt-BR"}
 
-// Repeat "Z" 4 times
-var MaxSegment = strings.Repeat(string(ABC[len(ABC)-1]), STEP_LENGTH)
+// Time between two digests of each frequency (DIGEST_OFF has none)
+var DigestPeriods = map[string]time.Duration{
+	DIGEST_DAILY:  time.Hour * 24,
+	DIGEST_WEEKLY: time.Hour * 24 * 7,
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Crops the largest centered square out of img
+func CropSquare(img image.Image) image.Image {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+
+	// Offset of the square inside the source image
+	origin := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), img, origin, draw.Src)
+
+	return dst
+}
+
+// Scales a square image to size x size.
+//
+// Each destination pixel is the average of the source pixels it covers (box filter),
+// which falls back to nearest-neighbour when upscaling.
+func ResizeSquare(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	for y := range size {
+		y0 := bounds.Min.Y + y*side/size
+		y1 := max(bounds.Min.Y+(y+1)*side/size, y0+1)
+
+		for x := range size {
+			x0 := bounds.Min.X + x*side/size
+			x1 := max(bounds.Min.X+(x+1)*side/size, x0+1)
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
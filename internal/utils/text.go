@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// A hashtag starts at the beginning of the message or after a character that cannot be part of a word
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_#&])#([\p{L}\p{N}_]{1,64})`)
+
+// Returns the lowercased, de-duplicated hashtags of a message in order of appearance.
+// Tags made only of digits (e.g. "#1") are ignored.
+func ParseHashtags(message string) []string {
+	var (
+		tags = []string{}
+		seen = map[string]bool{}
+	)
+
+	for _, match := range hashtagPattern.FindAllStringSubmatch(message, -1) {
+		tag := strings.ToLower(match[1])
+
+		if seen[tag] || !strings.ContainsFunc(tag, unicode.IsLetter) {
+			continue
+		}
+
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// A mention must not be glued to a preceding word, which keeps e-mail addresses out
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@.])@([\p{L}\p{N}_]{1,32})`)
+
+type MentionToken struct {
+	Handle string
+	Offset int // position of "@" in UTF-16 code units, matching JavaScript string indexing
+	Length int // length of "@handle" in UTF-16 code units
+}
+
+// Returns every @handle of a message in order of appearance (duplicates included)
+func ParseMentions(message string) []MentionToken {
+	tokens := []MentionToken{}
+
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(message, -1) {
+		// match[2]:match[3] is the handle, the "@" sits right before it
+		start := match[2] - 1
+
+		tokens = append(tokens, MentionToken{
+			Handle: message[match[2]:match[3]],
+			Offset: utf16Len(message[:start]),
+			Length: utf16Len(message[start:match[3]]),
+		})
+	}
+
+	return tokens
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// Handles are ASCII so they read the same in every font and fit in a URL as-is
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Lowercases a profile handle (dropping a leading "@") and checks it can be taken:
+// allowed characters and length, not reserved and free of blocked words
+func NormalizeHandle(handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+
+	if len(handle) < HANDLE_MIN_LENGTH || len(handle) > HANDLE_MAX_LENGTH {
+		return "", fmt.Errorf("handle must be %d to %d characters long", HANDLE_MIN_LENGTH, HANDLE_MAX_LENGTH)
+	}
+
+	if !handlePattern.MatchString(handle) {
+		return "", errors.New("handle may only contain letters, digits and underscores")
+	}
+
+	if slices.Contains(ReservedHandles, handle) {
+		return "", fmt.Errorf("handle %q is reserved", handle)
+	}
+
+	squashed := strings.ReplaceAll(handle, "_", "")
+	for _, word := range BlockedHandleWords {
+		if strings.Contains(squashed, word) {
+			return "", fmt.Errorf("handle %q is not allowed", handle)
+		}
+	}
+
+	return handle, nil
+}
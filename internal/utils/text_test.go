@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseHashtags(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"#go", []string{"go"}},
+		{"Learning #Go and #go again", []string{"go"}},
+		{"#one,#two.#three", []string{"one", "two", "three"}},
+		{"snake_#case and a#b", []string{}}, // glued to a word
+		{"&#39; is an entity", []string{}},
+		{"##double", []string{}},
+		{"#1 and #2024 but #web3", []string{"web3"}}, // digits only are not tags
+		{"#café #日本語", []string{"café", "日本語"}},
+		{"#" + strings.Repeat("a", 65), []string{strings.Repeat("a", 64)}}, // cut at 64 characters
+	}
+
+	for _, tt := range tests {
+		got := ParseHashtags(tt.in)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseHashtags(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []MentionToken
+	}{
+		{"", []MentionToken{}},
+		{"@ann", []MentionToken{{"ann", 0, 4}}},
+		{"hi @ann and @bob", []MentionToken{{"ann", 3, 4}, {"bob", 12, 4}}},
+		{"@ann @ann", []MentionToken{{"ann", 0, 4}, {"ann", 5, 4}}}, // duplicates are kept
+		{"mail ann@example.com", []MentionToken{}},
+		{"@@ann or .@ann", []MentionToken{}},
+		// "é" is 2 bytes but 1 UTF-16 unit
+		{"café @ann", []MentionToken{{"ann", 5, 4}}},
+		// "😀" is 4 bytes and 2 UTF-16 units (a surrogate pair)
+		{"😀 @ann", []MentionToken{{"ann", 3, 4}}},
+		{"😀😀 @ann @bob", []MentionToken{{"ann", 5, 4}, {"bob", 10, 4}}},
+		// The length counts the handle in UTF-16 units as well
+		{"@zoë", []MentionToken{{"zoë", 0, 4}}},
+		{"(@ann)", []MentionToken{{"ann", 1, 4}}},
+	}
+
+	for _, tt := range tests {
+		got := ParseMentions(tt.in)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseMentions(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeHandle(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"ann_lee", "ann_lee", false},
+		{"  @Ann_Lee ", "ann_lee", false},
+		{"abc", "abc", false},
+		{strings.Repeat("a", HANDLE_MAX_LENGTH), strings.Repeat("a", HANDLE_MAX_LENGTH), false},
+		{"ab", "", true},
+		{strings.Repeat("a", HANDLE_MAX_LENGTH+1), "", true},
+		{"ann-lee", "", true},
+		{"ann.lee", "", true},
+		{"zoë", "", true}, // handles are ASCII only
+		{"Admin", "", true},
+		{"@support", "", true},
+		{"admin_2", "admin_2", false}, // only exact reserved handles are refused
+		{"sh_it_head", "", true},      // blocked words are found across underscores
+		{"BITCHY", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeHandle(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeHandle(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeHandle(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
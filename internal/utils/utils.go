@@ -21,33 +21,66 @@ func NewCustomLogger() *slog.Logger {
 	)
 }
 
+// Encodes i as one path segment: a length character followed by i in base 36.
+// The length prefix makes longer numbers sort after shorter ones ("1Z" < "210"),
+// so segments stay in numeric order under plain byte comparison without any fixed width.
 func ConvertIntToPath(i int) (string, error) {
-	// Convert to a string using numerical base equal to ABC length
-	path := strconv.FormatInt(int64(i), len(ABC))
-
-	if len(path) > 4 {
-		return "", errors.New("cannot convert int to str. supplied integer is too large")
+	if i < 0 {
+		return "", errors.New("cannot convert int to str. supplied integer is negative")
 	}
 
-	// left-pad with "0" until it reaches length of 4
-	path = strings.ToUpper(padStart(path, "0", (STEP_LENGTH - len(path))))
-	return path, nil
+	// Convert to a string using numerical base equal to ABC length
+	digits := strings.ToUpper(strconv.FormatInt(int64(i), len(ABC)))
+
+	// An int64 has at most 13 base-36 digits, far below the 35 a single length character can express
+	return string(ABC[len(digits)]) + digits, nil
 }
 
-func ConvertPathToInt(path string) (int, error) {
-	num, err := strconv.ParseInt(path, len(ABC), 0)
+// Decodes a single segment produced by ConvertIntToPath
+func ConvertPathToInt(segment string) (int, error) {
+	if len(segment) < 2 || strings.IndexByte(ABC, segment[0]) != len(segment)-1 {
+		return 0, errors.New("malformed path segment")
+	}
+
+	num, err := strconv.ParseInt(segment[1:], len(ABC), 0)
 	if err != nil {
 		return 0, err
 	}
 	return int(num), nil
 }
 
+// Splits a path into its segments. Segments are self-delimiting, so they can only be read left to right.
+func SplitPath(path string) ([]string, error) {
+	segments := []string{}
+
+	for len(path) > 0 {
+		length := strings.IndexByte(ABC, path[0])
+		if length < 1 || len(path) < length+1 {
+			return nil, errors.New("malformed path")
+		}
+
+		segments = append(segments, path[:length+1])
+		path = path[length+1:]
+	}
+
+	return segments, nil
+}
+
 func IncrementPath(path string) (string, error) {
-	// Exclude the last 4 characters, which is the latest root path, to get parent path
-	parentPath := path[0 : len(path)-STEP_LENGTH]
+	segments, err := SplitPath(path)
+	if err != nil {
+		return "", err
+	}
 
-	// Parse only the latest root path (last 4 characters of path)
-	stepInt, err := ConvertPathToInt(path[len(path)-4:])
+	if len(segments) == 0 {
+		return "", errors.New("cannot increment an empty path")
+	}
+
+	// Everything but the last segment is the parent path
+	last := segments[len(segments)-1]
+	parentPath := path[:len(path)-len(last)]
+
+	stepInt, err := ConvertPathToInt(last)
 	if err != nil {
 		return "", err
 	}
@@ -61,7 +94,3 @@ func IncrementPath(path string) (string, error) {
 	// append incremented path to parentPath
 	return parentPath + newPath, nil
 }
-
-func padStart(s, fill string, count int) string {
-	return strings.Repeat(fill, count) + s
-}
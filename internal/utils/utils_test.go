@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestConvertIntToPath(t *testing.T) {
+	tests := []struct {
+		in   int
+		want string
+	}{
+		{0, "10"},
+		{1, "11"},
+		{35, "1Z"},
+		{36, "210"},        // second digit: the length character rolls over from 1 to 2
+		{36*36 - 1, "2ZZ"}, // largest two-digit segment
+		{36 * 36, "3100"},
+		{36*36*36*36 - 1, "4ZZZZ"}, // largest sibling the old fixed 4-character encoding could hold
+		{36 * 36 * 36 * 36, "510000"},
+	}
+
+	for _, tt := range tests {
+		got, err := ConvertIntToPath(tt.in)
+		if err != nil {
+			t.Fatalf("ConvertIntToPath(%d) error: %s", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("ConvertIntToPath(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+
+		back, err := ConvertPathToInt(got)
+		if err != nil {
+			t.Fatalf("ConvertPathToInt(%q) error: %s", got, err)
+		}
+		if back != tt.in {
+			t.Errorf("ConvertPathToInt(%q) = %d, want %d", got, back, tt.in)
+		}
+	}
+
+	if _, err := ConvertIntToPath(-1); err == nil {
+		t.Error("ConvertIntToPath(-1) should fail")
+	}
+}
+
+func TestConvertPathToIntMalformed(t *testing.T) {
+	for _, segment := range []string{"", "1", "21", "11Z", "0"} {
+		if _, err := ConvertPathToInt(segment); err == nil {
+			t.Errorf("ConvertPathToInt(%q) should fail", segment)
+		}
+	}
+}
+
+// Comment paths are compared byte by byte (collation "C"), which Go string comparison matches
+func TestPathOrdering(t *testing.T) {
+	var paths []string
+	for _, i := range []int{0, 1, 9, 10, 35, 36, 37, 1295, 1296, 46655, 46656, 1679615, 1679616} {
+		path, err := ConvertIntToPath(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	if !slices.IsSorted(paths) {
+		t.Errorf("sibling paths out of numeric order under byte comparison: %v", paths)
+	}
+
+	// A parent sorts right before its own replies, and all of them before the next sibling
+	tree := []string{"11", "1111", "111121Z", "1112", "12"}
+	if !slices.IsSorted(tree) {
+		t.Errorf("tree paths out of depth-first order: %v", tree)
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{}, false},
+		{"11", []string{"11"}, false},
+		{"11210", []string{"11", "210"}, false},
+		{"3100102ZZ", []string{"3100", "10", "2ZZ"}, false},
+		{"2", nil, true},      // length character without its digits
+		{"1121", nil, true},   // truncated last segment
+		{"0011", nil, true},   // "0" is not a valid length
+		{"11a1", nil, true},   // lowercase is not part of the alphabet
+		{"110001", nil, true}, // old fixed-width segments are not valid
+	}
+
+	for _, tt := range tests {
+		got, err := SplitPath(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SplitPath(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("SplitPath(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIncrementPath(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"10", "11", false},
+		{"19", "1A", false},
+		{"1Z", "210", false}, // rolls over into a wider segment
+		{"112ZZ", "113100", false},
+		{"11121Z", "1112210", false}, // only the last segment changes
+		{"", "", true},
+		{"1", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := IncrementPath(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("IncrementPath(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("IncrementPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if !tt.wantErr && got <= tt.in {
+			t.Errorf("IncrementPath(%q) = %q does not sort after its input", tt.in, got)
+		}
+	}
+}
+
+// Same steps as pg_temp."reencodePath" in db/migrations/0006_comment_paths.sql
+func reencodeOldPath(old string) string {
+	var result strings.Builder
+
+	for i := 0; i+4 <= len(old); i += 4 {
+		n := 0
+		for _, c := range old[i : i+4] {
+			n = n*36 + strings.IndexRune(ABC, c)
+		}
+
+		digits := ""
+		for {
+			digits = string(ABC[n%36]) + digits
+			n /= 36
+			if n == 0 {
+				break
+			}
+		}
+
+		result.WriteString(string(ABC[len(digits)]) + digits)
+	}
+
+	return result.String()
+}
+
+func TestReencodeOldPaths(t *testing.T) {
+	tests := []struct {
+		old  string
+		want string
+	}{
+		{"0000", "10"},
+		{"0001", "11"},
+		{"000Z", "1Z"},
+		{"0010", "210"},
+		{"0100", "3100"},
+		{"ZZZZ", "4ZZZZ"},
+		{"00010002", "1112"},
+		{"0001001000ZZ", "112102ZZ"},
+	}
+
+	for _, tt := range tests {
+		got := reencodeOldPath(tt.old)
+		if got != tt.want {
+			t.Errorf("reencode(%q) = %q, want %q", tt.old, got, tt.want)
+		}
+
+		// Every old segment must come back as the segment ConvertIntToPath writes today
+		segments, err := SplitPath(got)
+		if err != nil {
+			t.Fatalf("SplitPath(%q) error: %s", got, err)
+		}
+		if len(segments) != len(tt.old)/4 {
+			t.Fatalf("reencode(%q) has %d segments, want %d", tt.old, len(segments), len(tt.old)/4)
+		}
+
+		for k, segment := range segments {
+			want, _ := strconv.ParseInt(tt.old[k*4:k*4+4], 36, 0)
+			if got, err := ConvertPathToInt(segment); err != nil || got != int(want) {
+				t.Errorf("reencode(%q) segment %d = %q, want the encoding of %d", tt.old, k, segment, want)
+			}
+		}
+	}
+
+	// Re-encoding keeps the order old paths had under byte comparison
+	old := []string{"0001", "00010001", "00010002", "0001000A", "00010010", "0002", "000Z", "0010", "ZZZZ"}
+	if !slices.IsSorted(old) {
+		t.Fatalf("fixture out of order: %v", old)
+	}
+
+	reencoded := []string{}
+	for _, path := range old {
+		reencoded = append(reencoded, reencodeOldPath(path))
+	}
+	if !slices.IsSorted(reencoded) {
+		t.Errorf("re-encoded paths changed order: %v", reencoded)
+	}
+}